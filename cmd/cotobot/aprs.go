@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	knotsToMs = 0.514444
+	feetToM   = 0.3048
+)
+
+var errNoPosition = errors.New("no position in packet")
+
+type aprsPacket struct {
+	src string
+	loc *Location
+}
+
+// aprsReader reads APRS-IS text stream and sends positions of known senders to TAK
+func (app *App) aprsReader() {
+	logger := app.logger.With("logger", "aprs")
+
+	for {
+		if err := app.aprsConnect(); err != nil {
+			logger.Error("aprs connection error", "error", err.Error())
+		}
+
+		time.Sleep(app.config.Duration("aprs.reconnect"))
+	}
+}
+
+func (app *App) aprsConnect() error {
+	logger := app.logger.With("logger", "aprs")
+
+	conn, err := net.DialTimeout("tcp", app.config.String("aprs.server"), time.Second*10)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	logger.Info("connected to " + app.config.String("aprs.server"))
+
+	if login := app.config.String("aprs.login"); login != "" {
+		pass := app.config.String("aprs.pass")
+		if pass == "" {
+			pass = "-1"
+		}
+
+		s := fmt.Sprintf("user %s pass %s vers cotobot %s", login, pass, getVersion())
+		if filter := app.config.String("aprs.filter"); filter != "" {
+			s += " filter " + filter
+		}
+
+		if _, err := conn.Write([]byte(s + "\r\n")); err != nil {
+			return err
+		}
+	}
+
+	sc := bufio.NewScanner(conn)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+
+		// server comments
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		p, err := parseAprs(line)
+		if err != nil {
+			if !errors.Is(err, errNoPosition) {
+				logger.Debug("invalid packet "+line, "error", err.Error())
+			}
+
+			continue
+		}
+
+		user := app.externalUser("aprs", p.src, p.src)
+		if user == nil {
+			continue
+		}
+
+		app.processLocation(user, user.Login, "aprs", p.loc)
+	}
+
+	return sc.Err()
+}
+
+// parseAprs parses position from APRS-IS packet like N0CALL-9>APRS,TCPIP*:!5545.00N/03737.00E>090/010
+func parseAprs(line string) (*aprsPacket, error) {
+	header, payload, ok := strings.Cut(line, ":")
+	if !ok || payload == "" {
+		return nil, fmt.Errorf("invalid packet")
+	}
+
+	src, _, ok := strings.Cut(header, ">")
+	if !ok || src == "" {
+		return nil, fmt.Errorf("invalid header")
+	}
+
+	var pos string
+
+	switch payload[0] {
+	case '!', '=':
+		pos = payload[1:]
+	case '/', '@':
+		// timestamp DDHHMMz
+		if len(payload) < 8 {
+			return nil, fmt.Errorf("short packet")
+		}
+
+		pos = payload[8:]
+	default:
+		return nil, errNoPosition
+	}
+
+	p := &aprsPacket{src: strings.ToUpper(src)}
+
+	var err error
+
+	if len(pos) > 0 && (pos[0] >= '0' && pos[0] <= '9' || pos[0] == ' ') {
+		err = parseAprsUncompressed(pos, p)
+	} else {
+		err = parseAprsCompressed(pos, p)
+	}
+
+	if err == nil {
+		parseAprsAltitude(pos, p)
+	}
+
+	return p, err
+}
+
+// parseAprsAltitude parses /A=aaaaaa altitude in feet from comment
+func parseAprsAltitude(s string, p *aprsPacket) {
+	_, a, ok := strings.Cut(s, "/A=")
+	if !ok || len(a) < 6 {
+		return
+	}
+
+	if ft, err := strconv.Atoi(a[:6]); err == nil {
+		p.loc.Alt = float64(ft) * feetToM
+		p.loc.AltSrc = "GPS"
+	}
+}
+
+func parseAprsUncompressed(s string, p *aprsPacket) error {
+	// DDMM.mmN/DDDMM.mmE$
+	if len(s) < 19 {
+		return fmt.Errorf("short position")
+	}
+
+	lat, err := parseAprsCoord(s[0:7], 2)
+	if err != nil {
+		return err
+	}
+
+	switch s[7] {
+	case 'N':
+	case 'S':
+		lat = -lat
+	default:
+		return fmt.Errorf("invalid latitude")
+	}
+
+	lon, err := parseAprsCoord(s[9:17], 3)
+	if err != nil {
+		return err
+	}
+
+	switch s[17] {
+	case 'E':
+	case 'W':
+		lon = -lon
+	default:
+		return fmt.Errorf("invalid longitude")
+	}
+
+	p.loc = &Location{Lat: lat, Lon: lon}
+
+	// course/speed extension CSE/SPD
+	if ext := s[19:]; len(ext) >= 7 && ext[3] == '/' {
+		crs, err1 := strconv.Atoi(ext[0:3])
+		spd, err2 := strconv.Atoi(ext[4:7])
+
+		if err1 == nil && err2 == nil {
+			p.loc.Heading = float64(crs % 360)
+			p.loc.Speed = float64(spd) * knotsToMs
+		}
+	}
+
+	return nil
+}
+
+// parseAprsCoord parses DDMM.mm with position ambiguity spaces
+func parseAprsCoord(s string, degLen int) (float64, error) {
+	s = strings.ReplaceAll(s, " ", "0")
+
+	d, err := strconv.Atoi(s[:degLen])
+	if err != nil {
+		return 0, err
+	}
+
+	m, err := strconv.ParseFloat(s[degLen:], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return float64(d) + m/60, nil
+}
+
+func parseAprsCompressed(s string, p *aprsPacket) error {
+	// /YYYYXXXX$csT
+	if len(s) < 13 {
+		return fmt.Errorf("short compressed position")
+	}
+
+	y, err := base91(s[1:5])
+	if err != nil {
+		return err
+	}
+
+	x, err := base91(s[5:9])
+	if err != nil {
+		return err
+	}
+
+	p.loc = &Location{
+		Lat: 90 - float64(y)/380926,
+		Lon: -180 + float64(x)/190463,
+	}
+
+	c, sp, t := s[10], s[11], s[12]
+
+	// course/speed, not altitude or range
+	if c >= '!' && c <= 'z' && (t-33)>>3&3 != 2 {
+		p.loc.Heading = float64((int(c) - 33) * 4 % 360)
+		p.loc.Speed = (math.Pow(1.08, float64(sp)-33) - 1) * knotsToMs
+	}
+
+	return nil
+}
+
+func base91(s string) (int, error) {
+	n := 0
+
+	for _, c := range []byte(s) {
+		if c < 33 || c > 123 {
+			return 0, fmt.Errorf("invalid base91 char")
+		}
+
+		n = n*91 + int(c-33)
+	}
+
+	return n, nil
+}
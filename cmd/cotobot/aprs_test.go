@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func near(a, b, eps float64) bool {
+	return math.Abs(a-b) < eps
+}
+
+func TestParseAprs(t *testing.T) {
+	// examples from APRS protocol reference 1.0.1
+	for _, tc := range []struct {
+		line              string
+		src               string
+		lat, lon          float64
+		heading, speedKts float64
+		altFt             float64
+	}{
+		{line: "N0CALL-9>APRS,TCPIP*:!4903.50N/07201.75W-Test 001234", src: "N0CALL-9", lat: 49.058333, lon: -72.029167},
+		{line: "n0call>APRS:=4903.50S/07201.75E-", src: "N0CALL", lat: -49.058333, lon: 72.029167},
+		{line: "N0CALL>APRS:@092345z4903.50N/07201.75W>088/036", src: "N0CALL", lat: 49.058333, lon: -72.029167, heading: 88, speedKts: 36},
+		{line: "N0CALL>APRS:/092345z4903.50N/07201.75W>Test1234", src: "N0CALL", lat: 49.058333, lon: -72.029167},
+		{line: "N0CALL>APRS:!4903.50N/07201.75W-Test /A=001234", src: "N0CALL", lat: 49.058333, lon: -72.029167, altFt: 1234},
+		{line: "N0CALL>APRS:!4903.  N/07201.  W-", src: "N0CALL", lat: 49.05, lon: -72.016667},
+		{line: "N0CALL>APRS:=/5L!!<*e7>7P[", src: "N0CALL", lat: 49.5, lon: -72.75, heading: 88, speedKts: 36.2},
+		{line: "N0CALL>APRS:@092345z/5L!!<*e7> sT", src: "N0CALL", lat: 49.5, lon: -72.75},
+	} {
+		p, err := parseAprs(tc.line)
+		if err != nil {
+			t.Errorf("%s: %s", tc.line, err)
+			continue
+		}
+
+		if p.src != tc.src || !near(p.loc.Lat, tc.lat, 1e-5) || !near(p.loc.Lon, tc.lon, 1e-5) {
+			t.Errorf("%s: %s %f %f", tc.line, p.src, p.loc.Lat, p.loc.Lon)
+		}
+
+		if !near(p.loc.Heading, tc.heading, 1e-6) || !near(p.loc.Speed, tc.speedKts*knotsToMs, 0.1) {
+			t.Errorf("%s: heading %f speed %f", tc.line, p.loc.Heading, p.loc.Speed)
+		}
+
+		if !near(p.loc.Alt, tc.altFt*feetToM, 1e-6) {
+			t.Errorf("%s: altitude %f", tc.line, p.loc.Alt)
+		}
+	}
+}
+
+func TestParseAprsErrors(t *testing.T) {
+	for _, line := range []string{
+		"N0CALL>APRS:>status text",
+		"N0CALL>APRS::N0CALL-1  :message",
+	} {
+		if _, err := parseAprs(line); !errors.Is(err, errNoPosition) {
+			t.Errorf("%s: %v, no position expected", line, err)
+		}
+	}
+
+	for _, line := range []string{
+		"N0CALL",
+		">APRS:!4903.50N/07201.75W-",
+		"N0CALL>APRS:!4903.50X/07201.75W-",
+		"N0CALL>APRS:!4903.50N/07201.75Q-",
+		"N0CALL>APRS:!49O3.50N/07201.75W-",
+		"N0CALL>APRS:!4903.50N/072",
+		"N0CALL>APRS:@0923",
+		"N0CALL>APRS:=/5L!~<*e7>7P[",
+		"N0CALL>APRS:=/5L!!<*e7",
+	} {
+		if _, err := parseAprs(line); err == nil || errors.Is(err, errNoPosition) {
+			t.Errorf("%s: %v, error expected", line, err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"cotobot/cmd/cotobot/database"
+)
+
+// checkCallsign returns reason callsign can't be used by user, empty if it is ok
+func (app *App) checkCallsign(user *database.UserInfo, callsign string) string {
+	minLen, maxLen := app.config.Int("callsign.min_len"), app.config.Int("callsign.max_len")
+
+	if n := utf8.RuneCountInString(callsign); n < minLen || n > maxLen {
+		return fmt.Sprintf("callsign must be %d to %d characters long", minLen, maxLen)
+	}
+
+	if p := app.config.String("callsign.pattern"); p != "" {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			app.logger.Error("invalid callsign pattern", "error", err.Error())
+		} else if !re.MatchString(callsign) {
+			return "callsign contains not allowed characters"
+		}
+	}
+
+	if app.callsignReserved(callsign) {
+		return "callsign " + callsign + " is reserved"
+	}
+
+	if app.callsignTaken(user, callsign) {
+		return "callsign " + callsign + " is taken"
+	}
+
+	return ""
+}
+
+// callsignReserved checks reserved list, entries with trailing * reserve all callsigns with prefix
+func (app *App) callsignReserved(callsign string) bool {
+	for _, r := range app.config.Strings("callsign.reserved") {
+		if prefix, ok := strings.CutSuffix(r, "*"); ok {
+			if strings.HasPrefix(strings.ToLower(callsign), strings.ToLower(prefix)) {
+				return true
+			}
+
+			continue
+		}
+
+		if strings.EqualFold(r, callsign) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// callsignTaken checks bot users and TAK contacts in user's scope
+func (app *App) callsignTaken(user *database.UserInfo, callsign string) bool {
+	scope := app.userScope(user)
+
+	if app.users.CallsignTaken(callsign, app.scopeFilter(scope), user.Id) {
+		return true
+	}
+
+	if item := app.picture.Find(callsign, scope); item != nil && item.Uid != app.userUid(user) {
+		return true
+	}
+
+	return false
+}
+
+// nameCallsign makes callsign of user name: name itself if it is valid,
+// otherwise name with not allowed characters replaced or first free variant of it
+func (app *App) nameCallsign(user *database.UserInfo, name string) string {
+	if app.checkCallsign(user, name) == "" {
+		return name
+	}
+
+	cs := name
+
+	if p := app.config.String("callsign.pattern"); p != "" {
+		if re, err := regexp.Compile(p); err == nil {
+			cs = strings.Map(func(r rune) rune {
+				if re.MatchString(string(r)) {
+					return r
+				}
+
+				return '_'
+			}, cs)
+		}
+	}
+
+	if maxLen := app.config.Int("callsign.max_len"); utf8.RuneCountInString(cs) > maxLen {
+		cs = string([]rune(cs)[:maxLen])
+	}
+
+	if app.checkCallsign(user, cs) == "" {
+		return cs
+	}
+
+	return app.suggestCallsign(user, cs)
+}
+
+// suggestCallsign returns first free callsign with numeric suffix
+func (app *App) suggestCallsign(user *database.UserInfo, callsign string) string {
+	maxLen := app.config.Int("callsign.max_len")
+
+	for i := 2; i < 100; i++ {
+		suffix := fmt.Sprintf("%d", i)
+		base := []rune(callsign)
+
+		if len(base)+len(suffix) > maxLen {
+			base = base[:max(maxLen-len(suffix), 0)]
+		}
+
+		cs := string(base) + suffix
+		if app.checkCallsign(user, cs) == "" {
+			return cs
+		}
+	}
+
+	return ""
+}
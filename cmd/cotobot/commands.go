@@ -1,137 +1,454 @@
 package main
 
 import (
+	"cmp"
 	"fmt"
+	"slices"
+	"strconv"
 	"strings"
-
-	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"time"
 
 	"cotobot/cmd/cotobot/database"
 )
 
-func (app *App) start(update *tg.Update, user *database.UserInfo) (tg.Chattable, error) {
-	name := getName(update.Message.From)
-
-	text := fmt.Sprintf("Now, %s, you can share your location here and it will be visible on takserver.ru using ATAK client", name)
-	text += "\nchange callsign - /callsign\nchange team - /team\nchange role - /role"
+func (app *App) start(msg *InMessage, user *database.UserInfo) (*OutMessage, error) {
+	text := fmt.Sprintf("Now, %s, you can share your location here and it will be visible on takserver.ru using ATAK client", msg.Name)
+	text += "\nchange callsign - /callsign\nchange team - /team\nchange role - /role\nset status - /status\npause sharing - /pause\nprivacy settings - /privacy\njoin scope - /scope\nmy profile - /me"
 
-	msg := tg.NewMessage(update.SentFrom().ID, text)
-	return msg, nil
+	return msg.Reply(text), nil
 }
 
-func (app *App) callsign(update *tg.Update, user *database.UserInfo) (tg.Chattable, error) {
-	var message *tg.Message
+func (app *App) callsign(msg *InMessage, user *database.UserInfo) (*OutMessage, error) {
+	args := strings.Fields(msg.Text)
+	if len(args) == 0 {
+		return app.startDialog(msg, user, "callsign", "enter", "send new callsign")
+	}
 
-	if update.EditedMessage != nil {
-		message = update.EditedMessage
-	} else {
-		message = update.Message
+	answer, ok := app.setCallsign(msg, user, args[0])
+	if !ok {
+		// wait for other callsign or suggestion choice
+		if err := app.states.Save(&database.DialogState{UserId: user.Id, Name: "callsign", Step: "enter"}); err != nil {
+			return nil, err
+		}
 	}
 
-	if message == nil {
-		app.logger.Error("empty message")
-		return nil, nil
+	return answer, nil
+}
+
+// dialogCallsign asks for callsign again until free one is sent
+func (app *App) dialogCallsign(msg *InMessage, user *database.UserInfo, st *database.DialogState, input string) (*OutMessage, string, error) {
+	args := strings.Fields(input)
+	if len(args) == 0 {
+		return msg.Reply("send new callsign or /cancel"), st.Step, nil
 	}
 
-	args := message.CommandArguments()
-	if args == "" {
-		return tg.NewMessage(update.SentFrom().ID, "usage: /callsign <callsign>"), nil
+	answer, ok := app.setCallsign(msg, user, args[0])
+	if !ok {
+		return answer, st.Step, nil
 	}
 
-	newCs := strings.Fields(args)[0]
+	return answer, "", nil
+}
 
+// setCallsign changes callsign if it is allowed, otherwise returns reason with suggestion button
+func (app *App) setCallsign(msg *InMessage, user *database.UserInfo, newCs string) (*OutMessage, bool) {
 	if newCs != user.Callsign {
+		if reason := app.checkCallsign(user, newCs); reason != "" {
+			answer := msg.Reply(reason)
+
+			if s := app.suggestCallsign(user, newCs); s != "" {
+				answer.Text += ", try " + s
+				answer.Keyboard = makeKeyboard(1, Button{Text: s, Data: "dlg_" + s})
+			}
+
+			return answer, false
+		}
+
 		app.logger.Info(fmt.Sprintf("%s callsign %s -> %s", user.Id, user.Callsign, newCs))
 		user.Callsign = newCs
 		app.users.Save(user)
 	}
 
-	msg := tg.NewMessage(update.SentFrom().ID, getMessage(user))
-	msg.ReplyMarkup = tg.NewRemoveKeyboard(false)
+	answer := msg.Reply(getMessage(user))
+	answer.RemoveKeyboard = true
 
-	return msg, nil
+	return answer, true
 }
 
-func (app *App) team(update *tg.Update, user *database.UserInfo) (tg.Chattable, error) {
-	msg := tg.NewMessage(update.SentFrom().ID, "select team")
+func (app *App) status(msg *InMessage, user *database.UserInfo) (*OutMessage, error) {
+	text := strings.TrimSpace(msg.Text)
 
-	var keyboard [][]tg.InlineKeyboardButton
-	row := make([]tg.InlineKeyboardButton, 0)
-	for i, c := range colors {
-		row = append(row, tg.NewInlineKeyboardButtonData(c, "team_"+c))
-		if (i+1)%3 == 0 {
-			keyboard = append(keyboard, row)
-			row = make([]tg.InlineKeyboardButton, 0)
+	switch text {
+	case "":
+		if user.Status == "" {
+			return msg.Reply("usage: /status <text>, /status - to clear"), nil
 		}
+
+		return msg.Reply("your status: " + user.Status), nil
+	case "-":
+		text = ""
+	}
+
+	if len(text) > 200 {
+		return msg.Reply("status is too long"), nil
 	}
-	keyboard = append(keyboard, row)
 
-	msg.ReplyMarkup = tg.InlineKeyboardMarkup{
-		InlineKeyboard: keyboard,
+	if text != user.Status {
+		app.logger.Info(fmt.Sprintf("%s status %s -> %s", user.Id, user.Status, text))
+		user.Status = text
+		app.users.Save(user)
 	}
 
-	return msg, nil
+	if text == "" {
+		return msg.Reply("status cleared"), nil
+	}
+
+	return msg.Reply("your status: " + text), nil
 }
 
-func (app *App) role(update *tg.Update, user *database.UserInfo) (tg.Chattable, error) {
-	msg := tg.NewMessage(update.SentFrom().ID, "select role")
+func (app *App) alt(msg *InMessage, user *database.UserInfo) (*OutMessage, error) {
+	text := strings.TrimSpace(msg.Text)
 
-	var keyboard [][]tg.InlineKeyboardButton
-	row := make([]tg.InlineKeyboardButton, 0)
-	for i, c := range roles {
-		row = append(row, tg.NewInlineKeyboardButtonData(c, "role_"+c))
-		if (i+1)%3 == 0 {
-			keyboard = append(keyboard, row)
-			row = make([]tg.InlineKeyboardButton, 0)
+	switch text {
+	case "":
+		if user.Alt == nil {
+			return msg.Reply("usage: /alt <meters above sea level>, /alt - to use GPS or ground elevation"), nil
 		}
+
+		return msg.Reply(fmt.Sprintf("your altitude: %.0f m", *user.Alt)), nil
+	case "-":
+		app.logger.Info(fmt.Sprintf("%s altitude cleared", user.Id))
+		user.Alt = nil
+		app.users.Save(user)
+
+		return msg.Reply("altitude cleared"), nil
+	}
+
+	h, err := strconv.ParseFloat(strings.TrimSuffix(text, "m"), 64)
+	if err != nil || h < -500 || h > 9000 {
+		return msg.Reply("invalid altitude " + text), nil
+	}
+
+	app.logger.Info(fmt.Sprintf("%s altitude %.0f", user.Id, h))
+	user.Alt = &h
+	app.users.Save(user)
+
+	return msg.Reply(fmt.Sprintf("your altitude: %.0f m", h)), nil
+}
+
+func (app *App) pause(msg *InMessage, user *database.UserInfo) (*OutMessage, error) {
+	app.setPaused(user, true)
+
+	return msg.Reply("location sharing paused, /resume to continue"), nil
+}
+
+func (app *App) resume(msg *InMessage, user *database.UserInfo) (*OutMessage, error) {
+	app.setPaused(user, false)
+
+	return msg.Reply("location sharing resumed"), nil
+}
+
+// setPaused pauses or resumes location sharing, on pause user's point is removed from map
+func (app *App) setPaused(user *database.UserInfo, paused bool) {
+	if user.Paused == paused {
+		return
 	}
-	keyboard = append(keyboard, row)
 
-	msg.ReplyMarkup = tg.InlineKeyboardMarkup{
-		InlineKeyboard: keyboard,
+	if paused {
+		app.logger.Info(fmt.Sprintf("%s paused", user.Id))
+	} else {
+		app.logger.Info(fmt.Sprintf("%s resumed", user.Id))
 	}
 
-	return msg, nil
+	user.Paused = paused
+	app.users.Save(user)
+
+	if paused {
+		app.sendOffline(user, app.userScope(user))
+	}
 }
 
-func (app *App) callbackTeam(cq *tg.CallbackQuery, user *database.UserInfo, data string) (tg.Chattable, error) {
-	if data != user.Team {
-		app.logger.Info(fmt.Sprintf("%s team %s -> %s", user.Id, user.Team, data))
+func (app *App) privacy(msg *InMessage, user *database.UserInfo) (*OutMessage, error) {
+	args := strings.Fields(msg.Text)
+
+	if len(args) < 2 {
+		return msg.Reply(privacyMessage(user) +
+			"\n\nusage:\n/privacy grid <meters> - snap position to grid, 0 to disable" +
+			"\n/privacy hours 08:00-20:00 - share only in this time, off to disable" +
+			"\n/privacy area on|off - share only inside scope area"), nil
+	}
+
+	switch args[0] {
+	case "grid":
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 || n > 100000 {
+			return msg.Reply("invalid grid size " + args[1]), nil
+		}
 
-		user.Team = data
-		if user.Team == NO_TEAM {
-			user.Team = ""
+		user.Grid = n
+	case "hours":
+		if args[1] == "off" {
+			user.ShareFrom, user.ShareTo = "", ""
+			break
 		}
-		app.users.Save(user)
+
+		from, to, ok := strings.Cut(args[1], "-")
+		if _, err := parseClock(from); !ok || err != nil {
+			return msg.Reply("invalid hours " + args[1]), nil
+		}
+
+		if _, err := parseClock(to); err != nil {
+			return msg.Reply("invalid hours " + args[1]), nil
+		}
+
+		user.ShareFrom, user.ShareTo = from, to
+	case "area":
+		switch args[1] {
+		case "on":
+			if app.scopeArea(app.userScope(user)) == nil {
+				return msg.Reply("your scope has no area"), nil
+			}
+
+			user.ShareInArea = true
+		case "off":
+			user.ShareInArea = false
+		default:
+			return msg.Reply("usage: /privacy area on|off"), nil
+		}
+	default:
+		return msg.Reply("unknown setting " + args[0]), nil
+	}
+
+	app.logger.Info(fmt.Sprintf("%s privacy %s %s", user.Id, args[0], args[1]))
+	app.users.Save(user)
+
+	return msg.Reply(privacyMessage(user)), nil
+}
+
+func privacyMessage(user *database.UserInfo) string {
+	var sb strings.Builder
+
+	if user.Paused {
+		sb.WriteString("sharing: paused")
+	} else {
+		sb.WriteString("sharing: on")
 	}
 
-	app.request(tg.NewCallback(cq.ID, ""))
+	if user.Grid > 0 {
+		sb.WriteString(fmt.Sprintf("\ngrid: %d m", user.Grid))
+	} else {
+		sb.WriteString("\ngrid: off")
+	}
+
+	if user.ShareFrom != "" && user.ShareTo != "" {
+		sb.WriteString(fmt.Sprintf("\nhours: %s-%s", user.ShareFrom, user.ShareTo))
+	} else {
+		sb.WriteString("\nhours: any")
+	}
 
-	msg := tg.NewMessage(cq.From.ID, getMessage(user))
-	msg.ReplyMarkup = tg.NewRemoveKeyboard(false)
+	if user.ShareInArea {
+		sb.WriteString("\narea: only inside scope area")
+	}
 
-	return msg, nil
+	return sb.String()
 }
 
-func (app *App) callbackRole(cq *tg.CallbackQuery, user *database.UserInfo, data string) (tg.Chattable, error) {
+func (app *App) nearby(msg *InMessage, user *database.UserInfo) (*OutMessage, error) {
+	radius := app.config.Float64("nearby.radius")
+
+	if args := strings.Fields(msg.Text); len(args) > 0 {
+		r, err := parseRadius(args[0])
+		if err != nil || r <= 0 || r > app.config.Float64("nearby.max_radius") {
+			return msg.Reply("usage: /nearby [radius], e.g. /nearby 500 or /nearby 2km"), nil
+		}
+
+		radius = r
+	}
+
+	since := time.Now().Add(-app.config.Duration("nearby.max_age"))
+
+	if user.LastPos == nil || user.LastPos.Before(since) {
+		return msg.Reply("share your location first"), nil
+	}
+
+	users := app.users.Nearby(app.scopeFilter(app.userScope(user)), user.Lat, user.Lon, radius, since)
+	users = slices.DeleteFunc(users, func(u *database.UserInfo) bool { return u.Id == user.Id })
+
+	if len(users) == 0 {
+		return msg.Reply(fmt.Sprintf("nobody within %s", formatDistance(radius))), nil
+	}
+
+	slices.SortFunc(users, func(a, b *database.UserInfo) int {
+		return cmp.Compare(distance(user.Lat, user.Lon, a.Lat, a.Lon), distance(user.Lat, user.Lon, b.Lat, b.Lon))
+	})
+
+	var sb strings.Builder
+	buttons := make([]Button, 0, len(users))
+
+	sb.WriteString(fmt.Sprintf("within %s:", formatDistance(radius)))
+
+	for _, u := range users {
+		d := distance(user.Lat, user.Lon, u.Lat, u.Lon)
+		b := bearing(user.Lat, user.Lon, u.Lat, u.Lon)
+
+		sb.WriteString(fmt.Sprintf("\n%s", u.Callsign))
+		if u.Team != "" {
+			sb.WriteString(fmt.Sprintf(" (%s)", u.Team))
+		}
+
+		sb.WriteString(fmt.Sprintf(" %s %03.0f° %s, %s ago", formatDistance(d), b, compass(b), formatAge(time.Since(*u.LastPos))))
+		buttons = append(buttons, Button{Text: u.Callsign, Data: "venue_" + u.Id})
+	}
+
+	answer := msg.Reply(sb.String())
+	answer.Keyboard = makeKeyboard(3, buttons...)
+
+	return answer, nil
+}
+
+func (app *App) callbackVenue(msg *InMessage, user *database.UserInfo, data string) (*OutMessage, error) {
+	u := app.users.Find(data)
+	if u == nil || u.LastPos == nil || u.Paused || app.userScope(u) != app.userScope(user) {
+		return msg.Reply("position is not available"), nil
+	}
+
+	answer := msg.Reply("")
+	answer.Venue = &Venue{
+		Lat:     u.Lat,
+		Lon:     u.Lon,
+		Title:   u.Callsign,
+		Address: fmt.Sprintf("%s %s, %s ago", u.Team, u.Role, formatAge(time.Since(*u.LastPos))),
+	}
+
+	return answer, nil
+}
+
+func (app *App) where(msg *InMessage, user *database.UserInfo) (*OutMessage, error) {
+	callsign := strings.TrimSpace(msg.Text)
+	if callsign == "" {
+		return msg.Reply("usage: /where <callsign>"), nil
+	}
+
+	item := app.picture.Find(callsign, app.userScope(user))
+	if item == nil {
+		return msg.Reply(callsign + " not found"), nil
+	}
+
+	answer := msg.Reply("")
+	answer.Venue = &Venue{
+		Lat:     item.Lat,
+		Lon:     item.Lon,
+		Title:   item.Callsign,
+		Address: fmt.Sprintf("%s %s, %s ago", item.Type, item.Team, formatAge(time.Since(item.Updated))),
+	}
+
+	return answer, nil
+}
+
+func (app *App) showMap(msg *InMessage, user *database.UserInfo) (*OutMessage, error) {
+	radius := app.config.Float64("nearby.radius")
+
+	if args := strings.Fields(msg.Text); len(args) > 0 {
+		r, err := parseRadius(args[0])
+		if err != nil || r <= 0 || r > app.config.Float64("nearby.max_radius") {
+			return msg.Reply("usage: /map [radius], e.g. /map 2km"), nil
+		}
+
+		radius = r
+	}
+
+	since := time.Now().Add(-app.config.Duration("nearby.max_age"))
+
+	if user.LastPos == nil || user.LastPos.Before(since) {
+		return msg.Reply("share your location first"), nil
+	}
+
+	scope := app.userScope(user)
+	points := []mapPoint{{lat: user.Lat, lon: user.Lon, label: user.Callsign, color: teamColor(user.Team)}}
+	seen := map[string]bool{app.userUid(user): true}
+
+	for _, u := range app.users.Nearby(app.scopeFilter(scope), user.Lat, user.Lon, radius, since) {
+		if !seen[app.userUid(u)] {
+			seen[app.userUid(u)] = true
+			points = append(points, mapPoint{lat: u.Lat, lon: u.Lon, label: u.Callsign, color: teamColor(u.Team)})
+		}
+	}
+
+	for _, item := range app.picture.Around(user.Lat, user.Lon, radius, scope) {
+		if !seen[item.Uid] {
+			seen[item.Uid] = true
+			points = append(points, mapPoint{lat: item.Lat, lon: item.Lon, label: item.Callsign, color: teamColor(item.Team)})
+		}
+	}
+
+	img, err := renderMap(user.Lat, user.Lon, radius, app.config.String("map.tiles"), points)
+	if err != nil {
+		return nil, err
+	}
+
+	answer := msg.Reply(fmt.Sprintf("%d units within %s", len(points)-1, formatDistance(radius)))
+	answer.Photo = img
+
+	return answer, nil
+}
+
+func (app *App) team(msg *InMessage, user *database.UserInfo) (*OutMessage, error) {
+	answer := msg.Reply("select team")
+
+	answer.Keyboard = makeKeyboard(3, choiceButtons("team_", app.config.Strings("teams"))...)
+
+	return answer, nil
+}
+
+func (app *App) role(msg *InMessage, user *database.UserInfo) (*OutMessage, error) {
+	answer := msg.Reply("select role")
+
+	answer.Keyboard = makeKeyboard(3, choiceButtons("role_", app.config.Strings("roles"))...)
+
+	return answer, nil
+}
+
+func (app *App) callbackTeam(msg *InMessage, user *database.UserInfo, data string) (*OutMessage, error) {
+	app.setTeam(user, data)
+
+	answer := msg.Reply(getMessage(user))
+	answer.RemoveKeyboard = true
+
+	return answer, nil
+}
+
+func (app *App) callbackRole(msg *InMessage, user *database.UserInfo, data string) (*OutMessage, error) {
 	if user == nil {
 		app.logger.Error("user is nil")
 		return nil, nil
 	}
 
-	if data != user.Role {
-		app.logger.Info(fmt.Sprintf("%s role %s -> %s", user.Id, user.Role, data))
-		user.Role = data
-		app.users.Save(user)
-	}
+	app.setRole(user, data)
 
-	msg1 := tg.NewCallback(cq.ID, "")
-	app.request(msg1)
+	answer := msg.Reply(getMessage(user))
+	answer.RemoveKeyboard = true
 
-	msg := tg.NewMessage(cq.From.ID, getMessage(user))
-	msg.ReplyMarkup = tg.NewRemoveKeyboard(false)
+	return answer, nil
+}
+
+func (app *App) setTeam(user *database.UserInfo, team string) {
+	if team == NO_TEAM {
+		team = ""
+	}
 
-	return msg, nil
+	if team != user.Team {
+		app.logger.Info(fmt.Sprintf("%s team %s -> %s", user.Id, user.Team, team))
+		user.Team = team
+		app.users.Save(user)
+	}
+}
+
+func (app *App) setRole(user *database.UserInfo, role string) {
+	if role != user.Role {
+		app.logger.Info(fmt.Sprintf("%s role %s -> %s", user.Id, user.Role, role))
+		user.Role = role
+		app.users.Save(user)
+	}
 }
 
 func getMessage(user *database.UserInfo) string {
@@ -141,3 +458,46 @@ func getMessage(user *database.UserInfo) string {
 
 	return fmt.Sprintf("your callsign is %s, type %s", user.Callsign, user.CotType)
 }
+
+// isAdmin checks user id or login in admins list
+func (app *App) isAdmin(user *database.UserInfo) bool {
+	for _, a := range app.config.Strings("admins") {
+		if a == user.Id || (user.Login != "" && strings.EqualFold(strings.TrimPrefix(a, "@"), user.Login)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (app *App) audit(msg *InMessage, user *database.UserInfo) (*OutMessage, error) {
+	if !app.isAdmin(user) {
+		return msg.Reply("only admins can see audit log"), nil
+	}
+
+	id := ""
+
+	if arg := strings.TrimSpace(msg.Text); arg != "" {
+		if u := app.users.FindCallsign(arg); u != nil {
+			id = u.Id
+		} else if u := app.users.Find(arg); u != nil {
+			id = u.Id
+		} else {
+			return msg.Reply(arg + " not found"), nil
+		}
+	}
+
+	records := app.users.Audit(id, 20)
+	if len(records) == 0 {
+		return msg.Reply("no records"), nil
+	}
+
+	var sb strings.Builder
+
+	for _, r := range records {
+		sb.WriteString(fmt.Sprintf("%s %s: %s %s -> %s by %s\n",
+			r.At.In(app.location()).Format("2006-01-02 15:04"), r.Target, r.Field, r.OldValue, r.NewValue, r.Actor))
+	}
+
+	return msg.Reply(sb.String()), nil
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
+	"reflect"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/knadh/koanf/parsers/yaml"
@@ -12,24 +16,46 @@ import (
 	"github.com/knadh/koanf/v2"
 )
 
+// restartKeys are settings applied only on start
+var restartKeys = []string{
+	"token", "scope", "webhook", "database", "db", "users", "cot.proto", "cot.server", "cot.interface", "cot.ttl", "cot.loopback",
+	"cot.unicast", "cot.http", "cot.retry", "aprs", "meshtastic", "tak", "mission", "altitude",
+}
+
 type AppConfig struct {
-	k *koanf.Koanf
+	k         atomic.Pointer[koanf.Koanf]
+	files     []string
+	envPrefix string
+	errors    []error
+	name      string
+	tenants   []*AppConfig
 }
 
 func NewAppConfig() *AppConfig {
-	c := &AppConfig{k: koanf.New(".")}
-	setDefaults(c.k)
+	c := new(AppConfig)
+	k := koanf.New(".")
+	setDefaults(k)
+	c.k.Store(k)
 
 	return c
 }
 
+func (c *AppConfig) get() *koanf.Koanf {
+	return c.k.Load()
+}
+
 func (c *AppConfig) Load(filename ...string) bool {
 	loaded := false
 
 	for _, name := range filename {
-		if err := c.k.Load(file.Provider(name), yaml.Parser()); err != nil {
+		if err := c.get().Load(file.Provider(name), yaml.Parser()); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				c.errors = append(c.errors, fmt.Errorf("error loading %s: %w", name, err))
+			}
+
 			slog.Info(fmt.Sprintf("error loading config: %s", err.Error()))
 		} else {
+			c.files = append(c.files, name)
 			loaded = true
 		}
 	}
@@ -38,22 +64,184 @@ func (c *AppConfig) Load(filename ...string) bool {
 }
 
 func (c *AppConfig) LoadEnv(prefix string) error {
-	return c.k.Load(env.Provider(prefix, ".", func(s string) string {
+	c.envPrefix = prefix
+
+	return loadEnv(c.get(), prefix)
+}
+
+func loadEnv(k *koanf.Koanf, prefix string) error {
+	return k.Load(env.Provider(prefix, ".", func(s string) string {
 		return strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(s, prefix), "_", "."))
 	}), nil)
 }
 
+// Reload reads config files and env again and replaces settings at once.
+// Settings that need restart keep old values, list of them is returned. Old config is kept on error.
+func (c *AppConfig) Reload() ([]string, error) {
+	k := koanf.New(".")
+	setDefaults(k)
+
+	for _, name := range c.files {
+		if err := k.Load(file.Provider(name), yaml.Parser()); err != nil {
+			return nil, fmt.Errorf("error loading config %s: %w", name, err)
+		}
+	}
+
+	if c.envPrefix != "" {
+		if err := loadEnv(k, c.envPrefix); err != nil {
+			return nil, err
+		}
+	}
+
+	next := &AppConfig{}
+	next.k.Store(k)
+
+	if errs := next.Validate(); len(errs) > 0 {
+		msgs := make([]string, 0, len(errs))
+		for _, e := range errs {
+			msgs = append(msgs, e.String())
+		}
+
+		return nil, fmt.Errorf("invalid config: %s", strings.Join(msgs, "; "))
+	}
+
+	changed := keepRestartKeys(c.get(), k, "")
+	c.k.Store(k)
+
+	if !c.multi() {
+		return changed, nil
+	}
+
+	// instances report own changes, root ones are merged into them
+	changed = nil
+
+	bots := k.Slices("bots")
+	if len(bots) != len(c.tenants) {
+		changed = append(changed, "bots")
+	}
+
+	for i, t := range c.tenants {
+		if i >= len(bots) {
+			break
+		}
+
+		tk := tenantConfig(k, bots[i])
+		changed = append(changed, keepRestartKeys(t.get(), tk, t.name+".")...)
+		t.k.Store(tk)
+	}
+
+	return changed, nil
+}
+
+// keepRestartKeys puts old values of changed restart settings to new config, they are applied on restart only
+func keepRestartKeys(old, k *koanf.Koanf, prefix string) []string {
+	var changed []string
+
+	for _, key := range restartKeys {
+		if reflect.DeepEqual(old.Get(key), k.Get(key)) {
+			continue
+		}
+
+		changed = append(changed, prefix+key)
+
+		k.Delete(key)
+
+		switch v := old.Get(key).(type) {
+		case nil:
+		case map[string]any:
+			_ = k.MergeAt(old.Cut(key), key)
+		default:
+			_ = k.Set(key, v)
+		}
+	}
+
+	return changed
+}
+
+// Tenants returns configs of bot instances from "bots" list, each one is global config
+// with instance settings merged over it. Without list config itself is the only instance.
+func (c *AppConfig) Tenants() []*AppConfig {
+	if c.tenants != nil {
+		return c.tenants
+	}
+
+	bots := c.get().Slices("bots")
+	if len(bots) == 0 {
+		c.tenants = []*AppConfig{c}
+		return c.tenants
+	}
+
+	for _, b := range bots {
+		t := &AppConfig{name: b.String("name")}
+		t.k.Store(tenantConfig(c.get(), b))
+		c.tenants = append(c.tenants, t)
+	}
+
+	return c.tenants
+}
+
+// Name returns bot instance name, empty in single bot mode
+func (c *AppConfig) Name() string {
+	return c.name
+}
+
+func (c *AppConfig) multi() bool {
+	return len(c.tenants) > 0 && c.tenants[0] != c
+}
+
+func tenantConfig(root, bot *koanf.Koanf) *koanf.Koanf {
+	k := root.Copy()
+	k.Delete("bots")
+	_ = k.Merge(bot)
+
+	return k
+}
+
+// Watch calls cb on config files change
+func (c *AppConfig) Watch(cb func()) error {
+	for _, name := range c.files {
+		if err := file.Provider(name).Watch(func(_ any, err error) {
+			if err != nil {
+				slog.Error("config watch error", "error", err.Error())
+				return
+			}
+
+			cb()
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *AppConfig) Exists(key string) bool {
+	return c.get().Exists(key)
+}
+
+func (c *AppConfig) Unmarshal(key string, v any) error {
+	return c.get().Unmarshal(key, v)
+}
+
 func (c *AppConfig) Bool(key string) bool {
-	return c.k.Bool(key)
+	return c.get().Bool(key)
 }
 
 func (c *AppConfig) String(key string) string {
-	return c.k.String(key)
+	return c.get().String(key)
+}
+
+func (c *AppConfig) Strings(key string) []string {
+	return c.get().Strings(key)
+}
+
+func (c *AppConfig) StringMap(key string) map[string]string {
+	return c.get().StringMap(key)
 }
 
 func (c *AppConfig) FirstString(key ...string) string {
 	for _, k := range key {
-		if s := c.k.String(k); s != "" {
+		if s := c.get().String(k); s != "" {
 			return s
 		}
 	}
@@ -62,20 +250,47 @@ func (c *AppConfig) FirstString(key ...string) string {
 }
 
 func (c *AppConfig) Float64(key string) float64 {
-	return c.k.Float64(key)
+	return c.get().Float64(key)
 }
 
 func (c *AppConfig) Int(key string) int {
-	return c.k.Int(key)
+	return c.get().Int(key)
 }
 
 func (c *AppConfig) Duration(key string) time.Duration {
-	return c.k.Duration(key)
+	return c.get().Duration(key)
 }
 
 func setDefaults(k *koanf.Koanf) {
 	k.Set("database", "bot.sqlite")
+	k.Set("scope", "test")
+	k.Set("users.cache_ttl", time.Minute)
+	k.Set("users.flush", time.Second*10)
 	k.Set("cot.proto", "tcp")
 	k.Set("cot.stale", time.Minute*10)
-	k.Set("cot.stale", time.Minute*10)
+	k.Set("cot.format", FormatMesh)
+	k.Set("cot.ttl", 1)
+	k.Set("cot.http.api", ApiJSON)
+	k.Set("cot.http.timeout", time.Second*5)
+	k.Set("cot.retry.queue", 1000)
+	k.Set("cot.retry.attempts", 5)
+	k.Set("cot.retry.delay", time.Second*5)
+	k.Set("webhook.check_delay", time.Second*10)
+	k.Set("aprs.only_known", true)
+	k.Set("meshtastic.only_known", true)
+	k.Set("aprs.reconnect", time.Second*30)
+	k.Set("meshtastic.topic", "msh/+/2/json/#")
+	k.Set("mission.poll", time.Minute)
+	k.Set("nearby.radius", 5000)
+	k.Set("nearby.max_radius", 100000)
+	k.Set("nearby.max_age", time.Hour)
+	k.Set("altitude.le.gps", 20)
+	k.Set("altitude.le.user", 5)
+	k.Set("altitude.le.srtm", 20)
+	k.Set("dialog.timeout", time.Minute*5)
+	k.Set("callsign.min_len", 2)
+	k.Set("callsign.max_len", 20)
+	k.Set("callsign.pattern", `^[\p{L}\p{N}_.-]+$`)
+	k.Set("teams", colors)
+	k.Set("roles", roles)
 }
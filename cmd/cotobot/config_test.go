@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+const testToken = "123456:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+
+func writeConfig(t *testing.T, name, data string) {
+	t.Helper()
+
+	if err := os.WriteFile(name, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAppConfigReload(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "cotobot.yml")
+	writeConfig(t, name, "token: "+testToken+"\ncot:\n  proto: udp\n  server: 127.0.0.1:8087\n  stale: 1m\n")
+
+	conf := NewAppConfig()
+	if !conf.Load(name) {
+		t.Fatal("config not loaded")
+	}
+
+	if errs := conf.Validate(); len(errs) > 0 {
+		t.Fatalf("udp proto rejected: %v", errs)
+	}
+
+	writeConfig(t, name, "token: "+testToken+"\ncot:\n  proto: http\n  server: http://127.0.0.1:8088\n  stale: 2m\n"+
+		"webhook:\n  path: /hook\n")
+
+	changed, err := conf.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(changed, []string{"webhook", "cot.proto", "cot.server"}) {
+		t.Errorf("changed %v", changed)
+	}
+
+	if d := conf.Duration("cot.stale"); d != time.Minute*2 {
+		t.Errorf("stale %s after reload", d)
+	}
+
+	// restart settings keep old values until restart
+	if p, s := conf.String("cot.proto"), conf.String("cot.server"); p != "udp" || s != "127.0.0.1:8087" {
+		t.Errorf("output changed without restart: %s %s", p, s)
+	}
+
+	if p := conf.String("webhook.path"); p != "" {
+		t.Errorf("webhook path changed without restart: %s", p)
+	}
+
+	writeConfig(t, name, "token: "+testToken+"\ncot:\n  proto: carrier-pigeon\n  stale: 3m\n")
+
+	if _, err := conf.Reload(); err == nil {
+		t.Error("invalid config reloaded")
+	}
+
+	if d := conf.Duration("cot.stale"); d != time.Minute*2 {
+		t.Errorf("old config is not kept, stale %s", d)
+	}
+}
+
+func TestAppConfigBotListener(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "cotobot.yml")
+	writeConfig(t, name, "token: "+testToken+"\nbots:\n  - name: one\n  - name: two\n    webhook:\n      listen: :8444\n")
+
+	conf := NewAppConfig()
+	if !conf.Load(name) {
+		t.Fatal("config not loaded")
+	}
+
+	errs := conf.Validate()
+	if len(errs) != 1 || errs[0].Key != "bots[1].webhook.listen" {
+		t.Errorf("per bot listener is not rejected: %v", errs)
+	}
+}
+
+func TestAppConfigDefaultScopeCode(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "cotobot.yml")
+	writeConfig(t, name, "token: "+testToken+"\nscope: test\nscopes:\n  test:\n    code: secret\n  ops:\n    code: secret\n")
+
+	conf := NewAppConfig()
+	if !conf.Load(name) {
+		t.Fatal("config not loaded")
+	}
+
+	errs := conf.Validate()
+	if len(errs) != 1 || errs[0].Key != "scopes.test.code" {
+		t.Errorf("code of default scope is not rejected: %v", errs)
+	}
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AuditRecord is append-only record of change made by actor to target user's field
+type AuditRecord struct {
+	Id     uint   `gorm:"primaryKey"`
+	Tenant string `gorm:"not null;default:'';index:idx_audit_target"`
+	// Actor is id of user who made change or name of system source like telegram
+	Actor    string    `gorm:"not null;default:'';index:idx_audit_actor"`
+	Target   string    `gorm:"not null;default:'';index:idx_audit_target"`
+	Field    string    `gorm:"not null;default:''"`
+	OldValue string    `gorm:"not null;default:''"`
+	NewValue string    `gorm:"not null;default:''"`
+	At       time.Time `gorm:"index:idx_audit_at"`
+}
+
+type AuditQuery struct {
+	Query[AuditRecord]
+	tenant *string
+	actor  string
+	target string
+	since  *time.Time
+}
+
+func NewAuditQuery(db *gorm.DB) *AuditQuery {
+	return &AuditQuery{
+		Query: Query[AuditRecord]{
+			db:     db,
+			limit:  100,
+			offset: 0,
+			order:  "at DESC",
+		},
+	}
+}
+
+func (q *AuditQuery) Order(s string) *AuditQuery {
+	q.order = s
+	return q
+}
+
+func (q *AuditQuery) Limit(n int) *AuditQuery {
+	q.limit = n
+	return q
+}
+
+func (q *AuditQuery) Tenant(tenant string) *AuditQuery {
+	q.tenant = &tenant
+	return q
+}
+
+func (q *AuditQuery) Actor(id string) *AuditQuery {
+	q.actor = id
+	return q
+}
+
+func (q *AuditQuery) Target(id string) *AuditQuery {
+	q.target = id
+	return q
+}
+
+// Since selects records newer than t
+func (q *AuditQuery) Since(t time.Time) *AuditQuery {
+	q.since = &t
+	return q
+}
+
+func (q *AuditQuery) where() *gorm.DB {
+	tx := q.db
+
+	if q.tenant != nil {
+		tx = tx.Where("tenant = ?", *q.tenant)
+	}
+
+	if q.actor != "" {
+		tx = tx.Where("actor = ?", q.actor)
+	}
+
+	if q.target != "" {
+		tx = tx.Where("target = ?", q.target)
+	}
+
+	if q.since != nil {
+		tx = tx.Where("at > ?", *q.since)
+	}
+
+	return tx
+}
+
+func (q *AuditQuery) Get() []*AuditRecord {
+	return q.get(q.where().Model(&AuditRecord{}))
+}
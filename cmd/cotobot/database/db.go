@@ -3,13 +3,24 @@ package database
 import (
 	"log/slog"
 	"strings"
+	"time"
 
 	"github.com/glebarez/sqlite"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
+// Pool is connection pool settings, zero values keep driver defaults
+type Pool struct {
+	MaxOpen     int
+	MaxIdle     int
+	MaxLifetime time.Duration
+	MaxIdleTime time.Duration
+}
+
+// GetDatabase opens database by dsn: "mysql:<dsn>", "postgres:<dsn>" or sqlite file name
 func GetDatabase(dsn string, debug bool) (*gorm.DB, error) {
 	conf := &gorm.Config{}
 
@@ -22,11 +33,15 @@ func GetDatabase(dsn string, debug bool) (*gorm.DB, error) {
 	var db *gorm.DB
 	var err error
 
-	if strings.HasPrefix(dsn, "mysql:") {
+	switch {
+	case strings.HasPrefix(dsn, "mysql:"):
 		slog.Info("open mysql database")
 		db, err = gorm.Open(mysql.Open(strings.TrimPrefix(dsn, "mysql:")), conf)
-	} else {
-		slog.Info("open mysql database " + dsn)
+	case strings.HasPrefix(dsn, "postgres:"):
+		slog.Info("open postgres database")
+		db, err = gorm.Open(postgres.Open(strings.TrimPrefix(dsn, "postgres:")), conf)
+	default:
+		slog.Info("open sqlite database " + dsn)
 		db, err = gorm.Open(sqlite.Open(dsn), conf)
 	}
 
@@ -37,3 +52,40 @@ func GetDatabase(dsn string, debug bool) (*gorm.DB, error) {
 
 	return db, nil
 }
+
+// SqliteFile returns file name of sqlite dsn, empty for mysql and postgres
+func SqliteFile(dsn string) string {
+	if strings.HasPrefix(dsn, "mysql:") || strings.HasPrefix(dsn, "postgres:") {
+		return ""
+	}
+
+	name, _, _ := strings.Cut(strings.TrimPrefix(dsn, "file:"), "?")
+
+	return name
+}
+
+// SetPool applies connection pool settings
+func SetPool(db *gorm.DB, p Pool) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	if p.MaxOpen > 0 {
+		sqlDB.SetMaxOpenConns(p.MaxOpen)
+	}
+
+	if p.MaxIdle > 0 {
+		sqlDB.SetMaxIdleConns(p.MaxIdle)
+	}
+
+	if p.MaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(p.MaxLifetime)
+	}
+
+	if p.MaxIdleTime > 0 {
+		sqlDB.SetConnMaxIdleTime(p.MaxIdleTime)
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DialogState is step of multi-step command user is in
+type DialogState struct {
+	Tenant  string `gorm:"primaryKey;default:''"`
+	UserId  string `gorm:"primaryKey"`
+	Name    string `gorm:"not null;default:''"`
+	Step    string `gorm:"not null;default:''"`
+	Data    string `gorm:"not null;default:''"`
+	Expires time.Time
+}
+
+// Values returns data collected on previous steps
+func (s *DialogState) Values() map[string]string {
+	res := make(map[string]string)
+
+	if s.Data != "" {
+		_ = json.Unmarshal([]byte(s.Data), &res)
+	}
+
+	return res
+}
+
+func (s *DialogState) SetValue(key, val string) {
+	m := s.Values()
+	m[key] = val
+
+	b, _ := json.Marshal(m)
+	s.Data = string(b)
+}
+
+type DialogQuery struct {
+	Query[DialogState]
+	tenant string
+	userId string
+}
+
+func NewDialogQuery(db *gorm.DB) *DialogQuery {
+	return &DialogQuery{
+		Query: Query[DialogState]{
+			db: db,
+		},
+	}
+}
+
+func (q *DialogQuery) Tenant(tenant string) *DialogQuery {
+	q.tenant = tenant
+	return q
+}
+
+func (q *DialogQuery) User(id string) *DialogQuery {
+	q.userId = id
+	return q
+}
+
+func (q *DialogQuery) where() *gorm.DB {
+	return q.db.Where("tenant = ? AND user_id = ?", q.tenant, q.userId)
+}
+
+func (q *DialogQuery) One() *DialogState {
+	return q.one(q.where().Model(&DialogState{}))
+}
+
+func (q *DialogQuery) Delete() error {
+	return q.where().Delete(&DialogState{}).Error
+}
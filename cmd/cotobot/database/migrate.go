@@ -0,0 +1,229 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is a versioned schema change. Up must work on sqlite and mysql,
+// models used inside should be frozen copies, not live structs.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+}
+
+// SchemaVersion is applied migration record
+type SchemaVersion struct {
+	Version   int    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"not null;default:''"`
+	AppliedAt time.Time
+}
+
+// MigrationState is migration with time it was applied at, nil if pending
+type MigrationState struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+var migrations = []Migration{
+	{Version: 1, Name: "user_infos", Up: migrateUserInfos},
+	{Version: 2, Name: "user_infos_tenant", Up: migrateUserInfosTenant},
+	{Version: 3, Name: "profile_audit", Up: migrateProfileAudit},
+	{Version: 4, Name: "dialog_states", Up: migrateDialogStates},
+}
+
+// Migrate applies pending migrations in order
+func Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&SchemaVersion{}); err != nil {
+		return err
+	}
+
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		slog.Info(fmt.Sprintf("apply migration %d %s", m.Version, m.Name))
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+
+			return tx.Create(&SchemaVersion{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+
+		if err != nil {
+			return fmt.Errorf("migration %d %s failed: %w", m.Version, m.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// MigrationStatus returns all known migrations with apply time, it doesn't change database.
+// Nil db is a database that doesn't exist yet, all migrations are pending then.
+func MigrationStatus(db *gorm.DB) ([]MigrationState, error) {
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]MigrationState, 0, len(migrations))
+
+	for _, m := range migrations {
+		st := MigrationState{Version: m.Version, Name: m.Name}
+		if v, ok := applied[m.Version]; ok {
+			st.AppliedAt = &v.AppliedAt
+		}
+
+		res = append(res, st)
+	}
+
+	return res, nil
+}
+
+func appliedVersions(db *gorm.DB) (map[int]SchemaVersion, error) {
+	if db == nil || !db.Migrator().HasTable(&SchemaVersion{}) {
+		return map[int]SchemaVersion{}, nil
+	}
+
+	var versions []SchemaVersion
+	if err := db.Order("version").Find(&versions).Error; err != nil {
+		return nil, err
+	}
+
+	res := make(map[int]SchemaVersion, len(versions))
+	for _, v := range versions {
+		res[v.Version] = v
+	}
+
+	return res, nil
+}
+
+// migrateUserInfos creates users table, adds missing columns to tables made by old AutoMigrate
+func migrateUserInfos(tx *gorm.DB) error {
+	type UserInfo struct {
+		Id          string `gorm:"primaryKey"`
+		Login       string `gorm:"not null;default:''"`
+		Callsign    string `gorm:"not null;default:''"`
+		Team        string `gorm:"not null;default:''"`
+		Role        string `gorm:"not null;default:''"`
+		CotType     string `gorm:"not null;default:''"`
+		Scope       string `gorm:"not null;default:''"`
+		Status      string `gorm:"not null;default:''"`
+		Alt         *float64
+		Paused      bool   `gorm:"not null;default:false"`
+		Grid        int    `gorm:"not null;default:0"`
+		ShareFrom   string `gorm:"not null;default:''"`
+		ShareTo     string `gorm:"not null;default:''"`
+		ShareInArea bool   `gorm:"not null;default:false"`
+		Fences      string `gorm:"not null;default:''"`
+		LastPos     *time.Time
+		Lat         float64 `gorm:"not null;default:0;index:idx_pos"`
+		Lon         float64 `gorm:"not null;default:0;index:idx_pos"`
+	}
+
+	return tx.AutoMigrate(&UserInfo{})
+}
+
+// migrateUserInfosTenant rebuilds users table with (tenant, id) primary key
+func migrateUserInfosTenant(tx *gorm.DB) error {
+	type UserInfoV2 struct {
+		Tenant      string `gorm:"primaryKey;default:''"`
+		Id          string `gorm:"primaryKey"`
+		Login       string `gorm:"not null;default:''"`
+		Callsign    string `gorm:"not null;default:''"`
+		Team        string `gorm:"not null;default:''"`
+		Role        string `gorm:"not null;default:''"`
+		CotType     string `gorm:"not null;default:''"`
+		Scope       string `gorm:"not null;default:''"`
+		Status      string `gorm:"not null;default:''"`
+		Alt         *float64
+		Paused      bool   `gorm:"not null;default:false"`
+		Grid        int    `gorm:"not null;default:0"`
+		ShareFrom   string `gorm:"not null;default:''"`
+		ShareTo     string `gorm:"not null;default:''"`
+		ShareInArea bool   `gorm:"not null;default:false"`
+		Fences      string `gorm:"not null;default:''"`
+		LastPos     *time.Time
+		Lat         float64 `gorm:"not null;default:0;index:idx_user_pos"`
+		Lon         float64 `gorm:"not null;default:0;index:idx_user_pos"`
+	}
+
+	cols := "id, login, callsign, team, role, cot_type, scope, status, alt, paused, grid, " +
+		"share_from, share_to, share_in_area, fences, last_pos, lat, lon"
+
+	if err := tx.Table("user_infos_new").Migrator().CreateTable(&UserInfoV2{}); err != nil {
+		return err
+	}
+
+	if err := tx.Exec("INSERT INTO user_infos_new (tenant, " + cols + ") SELECT '', " + cols + " FROM user_infos").Error; err != nil {
+		return err
+	}
+
+	if err := tx.Migrator().DropTable("user_infos"); err != nil {
+		return err
+	}
+
+	return tx.Migrator().RenameTable("user_infos_new", "user_infos")
+}
+
+// migrateProfileAudit adds name and first seen time to users and creates audit log table
+func migrateProfileAudit(tx *gorm.DB) error {
+	type UserInfo struct {
+		Name      string `gorm:"not null;default:''"`
+		FirstSeen *time.Time
+	}
+
+	type AuditRecord struct {
+		Id       uint      `gorm:"primaryKey"`
+		Tenant   string    `gorm:"not null;default:'';index:idx_audit_target"`
+		Actor    string    `gorm:"not null;default:'';index:idx_audit_actor"`
+		Target   string    `gorm:"not null;default:'';index:idx_audit_target"`
+		Field    string    `gorm:"not null;default:''"`
+		OldValue string    `gorm:"not null;default:''"`
+		NewValue string    `gorm:"not null;default:''"`
+		At       time.Time `gorm:"index:idx_audit_at"`
+	}
+
+	m := tx.Migrator()
+
+	for _, col := range []string{"Name", "FirstSeen"} {
+		if !m.HasColumn(&UserInfo{}, col) {
+			if err := m.AddColumn(&UserInfo{}, col); err != nil {
+				return err
+			}
+		}
+	}
+
+	// best guess for existing users
+	if err := tx.Exec("UPDATE user_infos SET first_seen = last_pos WHERE first_seen IS NULL").Error; err != nil {
+		return err
+	}
+
+	return m.CreateTable(&AuditRecord{})
+}
+
+func migrateDialogStates(tx *gorm.DB) error {
+	type DialogState struct {
+		Tenant  string `gorm:"primaryKey;default:''"`
+		UserId  string `gorm:"primaryKey"`
+		Name    string `gorm:"not null;default:''"`
+		Step    string `gorm:"not null;default:''"`
+		Data    string `gorm:"not null;default:''"`
+		Expires time.Time
+	}
+
+	return tx.Migrator().CreateTable(&DialogState{})
+}
@@ -7,7 +7,8 @@ import (
 	"gorm.io/gorm"
 )
 
-var errUpdate = errors.New("no record found")
+// ErrNotFound is returned on update if no record matches query
+var ErrNotFound = errors.New("no record found")
 
 type Query[T any] struct {
 	db     *gorm.DB
@@ -92,7 +93,7 @@ func (q *Query[T]) updateOrError(tx *gorm.DB, updates map[string]any) error {
 	}
 
 	if tx.RowsAffected == 0 {
-		return errUpdate
+		return ErrNotFound
 	}
 
 	return nil
@@ -1,13 +1,20 @@
 package database
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
 type UserQuery struct {
 	Query[UserInfo]
-	id    string
-	scope string
+	tenant   *string
+	id       string
+	callsign string
+	scopes   []string
+	since    *time.Time
+	bbox     []float64
+	active   bool
 }
 
 func NewUserQuery(db *gorm.DB) *UserQuery {
@@ -37,25 +44,75 @@ func (q *UserQuery) Offset(n int) *UserQuery {
 	return q
 }
 
+// Tenant selects users of one bot instance
+func (q *UserQuery) Tenant(tenant string) *UserQuery {
+	q.tenant = &tenant
+	return q
+}
+
 func (q *UserQuery) ID(id string) *UserQuery {
 	q.id = id
 	return q
 }
 
-func (q *UserQuery) Scope(scope string) *UserQuery {
-	q.scope = scope
+// Callsign selects user by callsign ignoring case
+func (q *UserQuery) Callsign(callsign string) *UserQuery {
+	q.callsign = callsign
+	return q
+}
+
+func (q *UserQuery) Scope(scope ...string) *UserQuery {
+	q.scopes = scope
+	return q
+}
+
+// Since selects users with position newer than t
+func (q *UserQuery) Since(t time.Time) *UserQuery {
+	q.since = &t
+	return q
+}
+
+// Bbox selects users with last position inside bounding box
+func (q *UserQuery) Bbox(minLat, minLon, maxLat, maxLon float64) *UserQuery {
+	q.bbox = []float64{minLat, minLon, maxLat, maxLon}
+	return q
+}
+
+// Active selects users who do not paused location sharing
+func (q *UserQuery) Active() *UserQuery {
+	q.active = true
 	return q
 }
 
 func (q *UserQuery) where() *gorm.DB {
 	tx := q.db
 
+	if q.tenant != nil {
+		tx = tx.Where("tenant = ?", *q.tenant)
+	}
+
 	if q.id != "" {
 		tx = tx.Where("id = ?", q.id)
 	}
 
-	if q.scope != "" {
-		tx = tx.Where("scope = ?", q.scope)
+	if q.callsign != "" {
+		tx = tx.Where("LOWER(callsign) = LOWER(?)", q.callsign)
+	}
+
+	if len(q.scopes) > 0 {
+		tx = tx.Where("scope IN ?", q.scopes)
+	}
+
+	if q.since != nil {
+		tx = tx.Where("last_pos > ?", *q.since)
+	}
+
+	if len(q.bbox) == 4 {
+		tx = tx.Where("lat BETWEEN ? AND ? AND lon BETWEEN ? AND ?", q.bbox[0], q.bbox[2], q.bbox[1], q.bbox[3])
+	}
+
+	if q.active {
+		tx = tx.Where("paused = ?", false)
 	}
 
 	return tx
@@ -3,12 +3,30 @@ package database
 import "time"
 
 type UserInfo struct {
-	Id       string `gorm:"primaryKey" yaml:"id"`
-	Login    string `gorm:"not null;default:''" yaml:"login"`
-	Callsign string `gorm:"not null;default:''" yaml:"callsign"`
-	Team     string `gorm:"not null;default:''" yaml:"team,omitempty"`
-	Role     string `gorm:"not null;default:''" yaml:"role"`
-	CotType  string `gorm:"not null;default:''" yaml:"type"`
-	Scope    string `gorm:"not null;default:''" yaml:"scope"`
-	LastPos  *time.Time
+	// Tenant is bot instance name, empty in single bot mode
+	Tenant   string   `gorm:"primaryKey;default:''" yaml:"-"`
+	Id       string   `gorm:"primaryKey" yaml:"id"`
+	Login    string   `gorm:"not null;default:''" yaml:"login"`
+	Callsign string   `gorm:"not null;default:''" yaml:"callsign"`
+	Team     string   `gorm:"not null;default:''" yaml:"team,omitempty"`
+	Role     string   `gorm:"not null;default:''" yaml:"role"`
+	CotType  string   `gorm:"not null;default:''" yaml:"type"`
+	Scope    string   `gorm:"not null;default:''" yaml:"scope"`
+	Status   string   `gorm:"not null;default:''" yaml:"status,omitempty"`
+	Alt      *float64 `yaml:"alt,omitempty"`
+	// privacy settings
+	Paused      bool   `gorm:"not null;default:false" yaml:"paused,omitempty"`
+	Grid        int    `gorm:"not null;default:0" yaml:"grid,omitempty"`
+	ShareFrom   string `gorm:"not null;default:''" yaml:"share_from,omitempty"`
+	ShareTo     string `gorm:"not null;default:''" yaml:"share_to,omitempty"`
+	ShareInArea bool   `gorm:"not null;default:false" yaml:"share_in_area,omitempty"`
+	// Fences is comma separated list of geofences user is inside
+	Fences string `gorm:"not null;default:''" yaml:"-"`
+	// Name is default callsign made from telegram name
+	Name      string     `gorm:"not null;default:''" yaml:"-"`
+	FirstSeen *time.Time `yaml:"first_seen,omitempty"`
+	// last known position
+	LastPos *time.Time
+	Lat     float64 `gorm:"not null;default:0;index:idx_user_pos" yaml:"-"`
+	Lon     float64 `gorm:"not null;default:0;index:idx_user_pos" yaml:"-"`
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"cotobot/cmd/cotobot/database"
+)
+
+// DialogHandler processes user's input on dialog step. Returns answer and next step, empty step finishes dialog.
+type DialogHandler func(msg *InMessage, user *database.UserInfo, st *database.DialogState, input string) (*OutMessage, string, error)
+
+// DialogStore keeps users' dialog states in db, so dialogs survive restarts
+type DialogStore struct {
+	logger *slog.Logger
+	db     *gorm.DB
+	tenant string
+	config *AppConfig
+}
+
+func NewDialogStore(db *gorm.DB, conf *AppConfig) *DialogStore {
+	return &DialogStore{
+		logger: slog.Default().With("logger", "dialogs", "tenant", conf.Name()),
+		db:     db,
+		tenant: conf.Name(),
+		config: conf,
+	}
+}
+
+// Get returns user's dialog state or nil, expired state is returned too
+func (ds *DialogStore) Get(userId string) *database.DialogState {
+	return database.NewDialogQuery(ds.db).Tenant(ds.tenant).User(userId).One()
+}
+
+// Save stores state and prolongs it for timeout, timeout is read from config to apply reload at once
+func (ds *DialogStore) Save(st *database.DialogState) error {
+	st.Tenant = ds.tenant
+	st.Expires = time.Now().Add(ds.config.Duration("dialog.timeout"))
+
+	err := ds.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(st).Error
+	if err != nil {
+		ds.logger.Error("save error", "error", err.Error())
+	}
+
+	return err
+}
+
+func (ds *DialogStore) Delete(userId string) error {
+	return database.NewDialogQuery(ds.db).Tenant(ds.tenant).User(userId).Delete()
+}
+
+// startDialog stores first step of dialog and returns prompt for user
+func (app *App) startDialog(msg *InMessage, user *database.UserInfo, name, step, prompt string) (*OutMessage, error) {
+	if err := app.states.Save(&database.DialogState{UserId: user.Id, Name: name, Step: step}); err != nil {
+		return nil, err
+	}
+
+	return msg.Reply(prompt + "\n/cancel to stop"), nil
+}
+
+// continueDialog passes input to active dialog, returns false if user is not in dialog
+func (app *App) continueDialog(msg *InMessage, user *database.UserInfo, input string) (*OutMessage, bool, error) {
+	st := app.states.Get(user.Id)
+	if st == nil {
+		return nil, false, nil
+	}
+
+	if st.Expires.Before(time.Now()) {
+		_ = app.states.Delete(user.Id)
+		return msg.Reply("too late, /" + st.Name + " timed out"), true, nil
+	}
+
+	h, ok := app.dialogs[st.Name]
+	if !ok {
+		_ = app.states.Delete(user.Id)
+		return nil, false, nil
+	}
+
+	answer, next, err := h(msg, user, st, input)
+	if err != nil {
+		return nil, true, err
+	}
+
+	if next == "" {
+		return answer, true, app.states.Delete(user.Id)
+	}
+
+	st.Step = next
+
+	return answer, true, app.states.Save(st)
+}
+
+func (app *App) cancel(msg *InMessage, user *database.UserInfo) (*OutMessage, error) {
+	if app.states.Get(user.Id) == nil {
+		return msg.Reply("nothing to cancel"), nil
+	}
+
+	if err := app.states.Delete(user.Id); err != nil {
+		return nil, err
+	}
+
+	answer := msg.Reply("cancelled")
+	answer.RemoveKeyboard = true
+
+	return answer, nil
+}
+
+// callbackDialog passes inline button choice to active dialog
+func (app *App) callbackDialog(msg *InMessage, user *database.UserInfo, data string) (*OutMessage, error) {
+	answer, ok, err := app.continueDialog(msg, user, data)
+	if !ok {
+		return msg.Reply("this question is not active any more"), err
+	}
+
+	return answer, err
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+const (
+	srtmVoid = -32768
+	// EGM96 15' grid WW15MGH.DAC: 721 rows from 90N to 90S, 1440 columns from 0E, int16 centimeters
+	geoidRows = 721
+	geoidCols = 1440
+)
+
+// Elevation looks up ground height from SRTM .hgt tiles and converts MSL heights to HAE
+type Elevation struct {
+	demDir string
+	geoid  []int16
+}
+
+func NewElevation(demDir, geoidFile string) (*Elevation, error) {
+	e := &Elevation{demDir: demDir}
+
+	if geoidFile != "" {
+		data, err := os.ReadFile(geoidFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(data) != geoidRows*geoidCols*2 {
+			return nil, fmt.Errorf("invalid geoid file size %d", len(data))
+		}
+
+		e.geoid = make([]int16, geoidRows*geoidCols)
+		for i := range e.geoid {
+			e.geoid[i] = int16(binary.BigEndian.Uint16(data[i*2:]))
+		}
+	}
+
+	return e, nil
+}
+
+// Undulation returns geoid height above WGS84 ellipsoid in meters, 0 if no geoid data
+func (e *Elevation) Undulation(lat, lon float64) float64 {
+	if e == nil || e.geoid == nil {
+		return 0
+	}
+
+	if lon < 0 {
+		lon += 360
+	}
+
+	y := (90 - lat) * 4
+	x := lon * 4
+
+	get := func(r, c int) float64 {
+		r = min(max(r, 0), geoidRows-1)
+		c = (c + geoidCols) % geoidCols
+
+		return float64(e.geoid[r*geoidCols+c]) / 100
+	}
+
+	return bilinear(x, y, get)
+}
+
+// Ground returns ground height MSL in meters from SRTM tile
+func (e *Elevation) Ground(lat, lon float64) (float64, bool) {
+	if e == nil || e.demDir == "" {
+		return 0, false
+	}
+
+	latBase, lonBase := math.Floor(lat), math.Floor(lon)
+
+	f, err := os.Open(filepath.Join(e.demDir, hgtName(int(latBase), int(lonBase))))
+	if err != nil {
+		return 0, false
+	}
+
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return 0, false
+	}
+
+	var size int
+
+	switch st.Size() {
+	case 1201 * 1201 * 2:
+		size = 1201
+	case 3601 * 3601 * 2:
+		size = 3601
+	default:
+		return 0, false
+	}
+
+	// rows go from north to south
+	y := (latBase + 1 - lat) * float64(size-1)
+	x := (lon - lonBase) * float64(size-1)
+
+	valid := true
+	buf := make([]byte, 2)
+
+	get := func(r, c int) float64 {
+		r = min(max(r, 0), size-1)
+		c = min(max(c, 0), size-1)
+
+		if _, err := f.ReadAt(buf, int64(r*size+c)*2); err != nil {
+			valid = false
+			return 0
+		}
+
+		v := int16(binary.BigEndian.Uint16(buf))
+		if v == srtmVoid {
+			valid = false
+		}
+
+		return float64(v)
+	}
+
+	h := bilinear(x, y, get)
+
+	return h, valid
+}
+
+func hgtName(lat, lon int) string {
+	ns, ew := 'N', 'E'
+
+	if lat < 0 {
+		ns, lat = 'S', -lat
+	}
+
+	if lon < 0 {
+		ew, lon = 'W', -lon
+	}
+
+	return fmt.Sprintf("%c%02d%c%03d.hgt", ns, lat, ew, lon)
+}
+
+func bilinear(x, y float64, get func(r, c int) float64) float64 {
+	c0, r0 := int(math.Floor(x)), int(math.Floor(y))
+	dx, dy := x-float64(c0), y-float64(r0)
+
+	v00 := get(r0, c0)
+	v01 := get(r0, c0+1)
+	v10 := get(r0+1, c0)
+	v11 := get(r0+1, c0+1)
+
+	return v00*(1-dx)*(1-dy) + v01*dx*(1-dy) + v10*(1-dx)*dy + v11*dx*dy
+}
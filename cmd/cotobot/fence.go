@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Fence is a circle or polygon area on the map
+type Fence struct {
+	Name string `koanf:"name"`
+	// Center is [lat, lon] of circle
+	Center []float64 `koanf:"center"`
+	// Radius of circle in meters
+	Radius float64 `koanf:"radius"`
+	// Polygon is list of [lat, lon] points
+	Polygon [][]float64 `koanf:"polygon"`
+	// Scope limits fence to users of this scope, empty for all
+	Scope string `koanf:"scope"`
+	// Chats to notify on enter and exit
+	Chats []string `koanf:"chats"`
+	// Alert sends geofence breach CoT to TAK
+	Alert bool `koanf:"alert"`
+	// On is enter, exit or empty for both
+	On string `koanf:"on"`
+}
+
+func (f *Fence) Validate() error {
+	if f.Name == "" || strings.Contains(f.Name, ",") {
+		return fmt.Errorf("invalid fence name %q", f.Name)
+	}
+
+	switch f.On {
+	case "", "enter", "exit":
+	default:
+		return fmt.Errorf("fence %s: invalid on value %s", f.Name, f.On)
+	}
+
+	switch {
+	case len(f.Center) == 2 && f.Radius > 0:
+		return nil
+	case len(f.Polygon) >= 3:
+		for _, p := range f.Polygon {
+			if len(p) != 2 {
+				return fmt.Errorf("fence %s: invalid polygon point %v", f.Name, p)
+			}
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("fence %s: center and radius or polygon of 3+ points required", f.Name)
+	}
+}
+
+func (f *Fence) Contains(lat, lon float64) bool {
+	if f == nil {
+		return false
+	}
+
+	if len(f.Center) == 2 && f.Radius > 0 {
+		return distance(f.Center[0], f.Center[1], lat, lon) <= f.Radius
+	}
+
+	return inPolygon(f.Polygon, lat, lon)
+}
+
+// inPolygon is ray casting point in polygon test
+func inPolygon(poly [][]float64, lat, lon float64) bool {
+	in := false
+
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		yi, xi := poly[i][0], poly[i][1]
+		yj, xj := poly[j][0], poly[j][1]
+
+		if (yi > lat) != (yj > lat) && lon < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			in = !in
+		}
+	}
+
+	return in
+}
+
+// scopeArea returns operation area of scope from config, nil if not set
+func (app *App) scopeArea(scope string) *Fence {
+	key := "scopes." + scope + ".area"
+
+	if !app.config.Exists(key) {
+		return nil
+	}
+
+	f := &Fence{Name: scope}
+	if err := app.config.Unmarshal(key, f); err != nil {
+		app.logger.Error("invalid area for scope "+scope, "error", err.Error())
+		return nil
+	}
+
+	if err := f.Validate(); err != nil {
+		app.logger.Error(err.Error())
+		return nil
+	}
+
+	return f
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/kdudkov/goatak/pkg/cot"
+)
+
+const (
+	// FormatMesh is TAK protocol v1 mesh: 0xbf 0x01 0xbf header and protobuf payload
+	FormatMesh = "mesh"
+	// FormatStream is TAK protocol v1 streaming: 0xbf, varint payload length and protobuf payload
+	FormatStream = "stream"
+	// FormatXML is plain CoT XML event
+	FormatXML = "xml"
+)
+
+// encodeCot makes wire representation of message in given format
+func encodeCot(msg *cot.CotMessage, format string) ([]byte, error) {
+	switch format {
+	case FormatMesh, "":
+		data, err := proto.Marshal(msg.TakMessage)
+		if err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, len(data)+3)
+		buf[0] = magicByte
+		buf[1] = 1
+		buf[2] = magicByte
+		copy(buf[3:], data)
+
+		return buf, nil
+	case FormatStream:
+		data, err := proto.Marshal(msg.TakMessage)
+		if err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, 1+binary.MaxVarintLen64+len(data))
+		buf[0] = magicByte
+		n := binary.PutUvarint(buf[1:], uint64(len(data)))
+		copy(buf[1+n:], data)
+
+		return buf[:1+n+len(data)], nil
+	case FormatXML:
+		data, err := xml.Marshal(cot.ProtoToEvent(msg.TakMessage))
+		if err != nil {
+			return nil, err
+		}
+
+		return append([]byte(xml.Header), data...), nil
+	default:
+		return nil, fmt.Errorf("unknown cot format %s", format)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const earthRadius = 6371000.0
+
+func toRad(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func toDeg(r float64) float64 {
+	return r * 180 / math.Pi
+}
+
+// distance returns great circle distance in meters
+func distance(lat1, lon1, lat2, lon2 float64) float64 {
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// bearing returns initial bearing from point 1 to point 2 in degrees
+func bearing(lat1, lon1, lat2, lon2 float64) float64 {
+	dLon := toRad(lon2 - lon1)
+
+	y := math.Sin(dLon) * math.Cos(toRad(lat2))
+	x := math.Cos(toRad(lat1))*math.Sin(toRad(lat2)) - math.Sin(toRad(lat1))*math.Cos(toRad(lat2))*math.Cos(dLon)
+
+	return math.Mod(toDeg(math.Atan2(y, x))+360, 360)
+}
+
+var compassPoints = []string{"N", "NE", "E", "SE", "S", "SW", "W", "NW"}
+
+func compass(b float64) string {
+	return compassPoints[int(math.Round(b/45))%8]
+}
+
+// parseRadius parses distance like 500, 500m or 2km
+func parseRadius(s string) (float64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	mult := 1.0
+	if strings.HasSuffix(s, "km") {
+		mult = 1000
+		s = strings.TrimSuffix(s, "km")
+	} else {
+		s = strings.TrimSuffix(s, "m")
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("invalid radius %s", s)
+	}
+
+	return f * mult, nil
+}
+
+func formatDistance(d float64) string {
+	if d < 1000 {
+		return fmt.Sprintf("%.0f m", d)
+	}
+
+	return fmt.Sprintf("%.1f km", d/1000)
+}
+
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%d s", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%d min", int(d.Minutes()))
+	default:
+		return fmt.Sprintf("%.1f h", d.Hours())
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+func TestParseRadius(t *testing.T) {
+	for s, want := range map[string]float64{"500": 500, "500m": 500, "2km": 2000, " 1.5KM ": 1500} {
+		if r, err := parseRadius(s); err != nil || r != want {
+			t.Errorf("parseRadius(%q) = %v, %v", s, r, err)
+		}
+	}
+
+	for _, s := range []string{"", "far", "nan", "NaNkm", "inf", "-Inf", "+infm"} {
+		if r, err := parseRadius(s); err == nil {
+			t.Errorf("parseRadius(%q) = %v, error expected", s, r)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kdudkov/goatak/pkg/cot"
+	"github.com/kdudkov/goatak/pkg/cotproto"
+
+	"cotobot/cmd/cotobot/database"
+)
+
+type geoJSON struct {
+	Features []struct {
+		Geometry struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+		Properties struct {
+			Name   string   `json:"name"`
+			Radius float64  `json:"radius"`
+			Scope  string   `json:"scope"`
+			Chats  []string `json:"chats"`
+			Alert  bool     `json:"alert"`
+			On     string   `json:"on"`
+		} `json:"properties"`
+	} `json:"features"`
+}
+
+// loadFences reads fences from config list and GeoJSON file
+func loadFences(conf *AppConfig) ([]*Fence, error) {
+	var fences []*Fence
+
+	if conf.Exists("fences") {
+		if err := conf.Unmarshal("fences", &fences); err != nil {
+			return nil, err
+		}
+	}
+
+	if fn := conf.String("fences_geojson"); fn != "" {
+		f, err := readGeoJSON(fn)
+		if err != nil {
+			return nil, err
+		}
+
+		fences = append(fences, f...)
+	}
+
+	for _, f := range fences {
+		if err := f.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return fences, nil
+}
+
+// readGeoJSON reads Polygon features and Point features with radius property
+func readGeoJSON(fn string) ([]*Fence, error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	var gj geoJSON
+	if err := json.Unmarshal(data, &gj); err != nil {
+		return nil, err
+	}
+
+	res := make([]*Fence, 0, len(gj.Features))
+
+	for i, ft := range gj.Features {
+		p := ft.Properties
+		f := &Fence{
+			Name:   cmp.Or(p.Name, fmt.Sprintf("fence%d", i+1)),
+			Radius: p.Radius,
+			Scope:  p.Scope,
+			Chats:  p.Chats,
+			Alert:  p.Alert,
+			On:     p.On,
+		}
+
+		switch ft.Geometry.Type {
+		case "Point":
+			var c []float64
+			if err := json.Unmarshal(ft.Geometry.Coordinates, &c); err != nil || len(c) < 2 {
+				return nil, fmt.Errorf("%s: invalid point", f.Name)
+			}
+
+			f.Center = []float64{c[1], c[0]}
+		case "Polygon":
+			var rings [][][]float64
+			if err := json.Unmarshal(ft.Geometry.Coordinates, &rings); err != nil || len(rings) == 0 {
+				return nil, fmt.Errorf("%s: invalid polygon", f.Name)
+			}
+
+			// outer ring only, geojson has lon, lat order
+			for _, c := range rings[0] {
+				if len(c) >= 2 {
+					f.Polygon = append(f.Polygon, []float64{c[1], c[0]})
+				}
+			}
+		default:
+			return nil, fmt.Errorf("%s: unsupported geometry %s", f.Name, ft.Geometry.Type)
+		}
+
+		res = append(res, f)
+	}
+
+	return res, nil
+}
+
+// checkFences compares fences user is inside with previous state and sends alerts on change
+func (app *App) checkFences(user *database.UserInfo, loc *Location) {
+	fences := *app.fences.Load()
+	if len(fences) == 0 {
+		return
+	}
+
+	scope := app.userScope(user)
+	was := splitList(user.Fences)
+	var now []string
+
+	for _, f := range fences {
+		if f.Scope != "" && f.Scope != scope {
+			continue
+		}
+
+		inside := f.Contains(loc.Lat, loc.Lon)
+		if inside {
+			now = append(now, f.Name)
+		}
+
+		wasInside := slices.Contains(was, f.Name)
+
+		switch {
+		case inside && !wasInside && f.On != "exit":
+			app.fenceAlert(f, user, loc, "entered")
+		case !inside && wasInside && f.On != "enter":
+			app.fenceAlert(f, user, loc, "left")
+		}
+	}
+
+	if s := strings.Join(now, ","); s != user.Fences {
+		user.Fences = s
+		app.users.Update(user.Id, map[string]any{"fences": s})
+	}
+}
+
+func (app *App) fenceAlert(f *Fence, user *database.UserInfo, loc *Location, action string) {
+	text := fmt.Sprintf("%s %s %s", user.Callsign, action, f.Name)
+	app.logger.Info("geofence: "+text, "id", user.Id)
+
+	for _, chat := range f.Chats {
+		if err := app.sendMsg(NewOutMessage(chat, text)); err != nil {
+			app.logger.Error("can't send fence alert to "+chat, "error", err.Error())
+		}
+	}
+
+	if f.Alert {
+		app.sendCotMessage(app.makeFenceAlert(f, user, loc, text))
+	}
+}
+
+// makeFenceAlert makes geo-fence breached alert CoT linked to user's point
+func (app *App) makeFenceAlert(f *Fence, user *database.UserInfo, loc *Location, text string) *cot.CotMessage {
+	scope := app.userScope(user)
+
+	evt := cot.BasicMsg("b-a-g", fmt.Sprintf("fence-%s-%s", f.Name, user.Id), time.Minute*5)
+	evt.CotEvent.How = "m-g"
+	evt.CotEvent.Lat = loc.Lat
+	evt.CotEvent.Lon = loc.Lon
+	evt.CotEvent.Access = scope
+
+	xd := cot.NewXMLDetails()
+	xd.AddPpLink(app.userUid(user), user.CotType, user.Callsign)
+	xd.AddChild("remarks", nil, text)
+
+	evt.CotEvent.Detail = &cotproto.Detail{
+		Contact:   &cotproto.Contact{Callsign: text},
+		XmlDetail: xd.AsXMLString(),
+	}
+
+	return &cot.CotMessage{TakMessage: evt, Scope: scope, Detail: xd}
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(s, ",")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kdudkov/goatak/pkg/cot"
+)
+
+const (
+	// ApiJSON posts cot.CotMessage json, goatak /cot endpoint
+	ApiJSON = "json"
+	// ApiXML posts CoT XML event, goatak /cot_xml endpoint and compatible servers
+	ApiXML = "xml"
+)
+
+// httpOutput posts CoT messages to HTTP API with one pooled client
+type httpOutput struct {
+	url     string
+	api     string
+	token   string
+	user    string
+	pass    string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newHttpOutput(conf *AppConfig) (*httpOutput, error) {
+	api := conf.String("cot.http.api")
+
+	switch api {
+	case ApiJSON, ApiXML:
+	default:
+		return nil, fmt.Errorf("unknown http api %s", api)
+	}
+
+	if _, err := url.Parse(conf.String("cot.server")); err != nil {
+		return nil, err
+	}
+
+	return &httpOutput{
+		url:     conf.String("cot.server"),
+		api:     api,
+		token:   conf.String("cot.http.token"),
+		user:    conf.String("cot.http.user"),
+		pass:    conf.String("cot.http.password"),
+		headers: conf.StringMap("cot.http.headers"),
+		client: &http.Client{
+			Timeout: conf.Duration("cot.http.timeout"),
+			Transport: &http.Transport{
+				MaxIdleConns:        10,
+				MaxIdleConnsPerHost: 10,
+				IdleConnTimeout:     time.Minute * 2,
+			},
+		},
+	}, nil
+}
+
+func (h *httpOutput) Send(msg *cot.CotMessage) error {
+	var data []byte
+	var err error
+	var contentType string
+
+	u := h.url
+
+	switch h.api {
+	case ApiXML:
+		contentType = "application/xml"
+		data, err = xml.Marshal(cot.ProtoToEvent(msg.TakMessage))
+
+		if msg.Scope != "" {
+			if strings.Contains(u, "?") {
+				u += "&scope=" + url.QueryEscape(msg.Scope)
+			} else {
+				u += "?scope=" + url.QueryEscape(msg.Scope)
+			}
+		}
+	default:
+		contentType = "application/json"
+		data, err = json.Marshal(msg)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	for k, v := range h.headers {
+		req.Header.Set(k, v)
+	}
+
+	switch {
+	case h.token != "":
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	case h.user != "":
+		req.SetBasicAuth(h.user, h.pass)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+
+	// read body to reuse connection
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return nil
+}
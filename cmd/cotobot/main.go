@@ -1,24 +1,25 @@
 package main
 
 import (
-	"bytes"
 	"cmp"
-	"encoding/json"
+	"encoding/csv"
+	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
 	"net"
-	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"google.golang.org/protobuf/proto"
-
-	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/kdudkov/goatak/pkg/cot"
 	"github.com/kdudkov/goatak/pkg/cotproto"
+	"gorm.io/gorm"
 
 	"cotobot/cmd/cotobot/database"
 )
@@ -48,111 +49,216 @@ var (
 	roles = []string{"Team Member", "HQ", "Team Lead", "K9", "Forward Observer", "Sniper", "Medic", "RTO"}
 )
 
-type Cb func(cq *tg.CallbackQuery, user *database.UserInfo, data string) (tg.Chattable, error)
+type Cb func(msg *InMessage, user *database.UserInfo, data string) (*OutMessage, error)
 
 type Command struct {
 	key  string
 	desc string
-	cb   func(update *tg.Update, user *database.UserInfo) (tg.Chattable, error)
+	cb   func(msg *InMessage, user *database.UserInfo) (*OutMessage, error)
 }
 
 type App struct {
 	config       *AppConfig
-	bot          *tg.BotAPI
+	transport    Transport
 	logger       *slog.Logger
 	defaultScope string
 	users        *UserManager
 	commands     map[string]*Command
 	callbacks    map[string]Cb
+	dialogs      map[string]DialogHandler
+	states       *DialogStore
+	fixes        sync.Map
+	elevation    *Elevation
+	fences       atomic.Pointer[[]*Fence]
+	picture      *Picture
+	missions     *missionSync
+	udp          *udpOutput
+	http         *httpOutput
+	retry        *retryQueue
 }
 
-func NewApp(conf *AppConfig) *App {
-	db, err := database.GetDatabase(conf.String("database"), false)
+func NewApp(conf *AppConfig, db *gorm.DB) *App {
+	elevation, err := NewElevation(conf.String("altitude.dem"), conf.String("altitude.geoid"))
+	if err != nil {
+		panic(err)
+	}
 
+	fences, err := loadFences(conf)
 	if err != nil {
 		panic(err)
 	}
 
+	logger := slog.Default()
+	if conf.Name() != "" {
+		logger = logger.With("tenant", conf.Name())
+	}
+
 	app := &App{
 		config:       conf,
-		bot:          nil,
-		logger:       slog.Default(),
-		defaultScope: "test",
-		users:        NewUserManager(db),
+		transport:    NewTelegramTransport(conf),
+		logger:       logger,
+		defaultScope: conf.String("scope"),
+		users:        NewUserManager(db, conf.Name(), conf.Duration("users.cache_ttl"), conf.Duration("users.flush")),
+		states:       NewDialogStore(db, conf),
 		commands:     make(map[string]*Command),
+		elevation:    elevation,
+		picture:      NewPicture(),
+		retry:        newRetryQueue(conf),
 	}
 
+	app.fences.Store(&fences)
+	app.users.callsign = app.nameCallsign
+
 	app.callbacks = map[string]Cb{
-		"team": app.callbackTeam,
-		"role": app.callbackRole,
+		"team":  app.callbackTeam,
+		"role":  app.callbackRole,
+		"venue": app.callbackVenue,
+		"dlg":   app.callbackDialog,
+		"me":    app.callbackMe,
+	}
+
+	app.dialogs = map[string]DialogHandler{
+		"callsign": app.dialogCallsign,
+		"scope":    app.dialogScope,
+	}
+
+	switch conf.String("cot.proto") {
+	case "multicast":
+		if app.udp, err = newUdpOutput(conf); err != nil {
+			panic(err)
+		}
+	case "http":
+		if app.http, err = newHttpOutput(conf); err != nil {
+			panic(err)
+		}
+	}
+
+	if conf.String("mission.url") != "" {
+		if app.missions, err = newMissionSync(app); err != nil {
+			panic(err)
+		}
 	}
 
 	return app
 }
 
-func (app *App) GetUpdatesChannel() (tg.UpdatesChannel, error) {
-	if webhook := app.config.String("webhook.ext"); webhook != "" {
-		app.logger.Info(fmt.Sprintf("start listener on %s, path %s", app.config.String("webhook.listen"), app.config.String("webhook.path")))
+// reloadConfig rereads config and applies settings that can be changed on the fly
+func reloadConfig(conf *AppConfig, apps []*App) {
+	changed, err := conf.Reload()
+	if err != nil {
+		slog.Error("config reload error, keep old config", "error", err.Error())
+		return
+	}
+
+	for _, app := range apps {
+		app.reloadFences()
+	}
+
+	for _, key := range changed {
+		slog.Warn(fmt.Sprintf("setting %s changed, restart required to apply it", key))
+	}
+
+	slog.Info("config reloaded")
+}
+
+func (app *App) reloadFences() {
+	fences, err := loadFences(app.config)
+	if err != nil {
+		app.logger.Error("fences reload error, keep old fences", "error", err.Error())
+		return
+	}
+
+	app.fences.Store(&fences)
+}
+
+// runApps runs bot instances until all of them stop or quit signal is received
+func runApps(conf *AppConfig, apps []*App) {
+	var wg sync.WaitGroup
+
+	for _, app := range apps {
+		wg.Add(1)
+
 		go func() {
-			if err := http.ListenAndServe(app.config.String("webhook.listen"), nil); err != nil {
-				panic(err)
-			}
+			defer wg.Done()
+			app.Run()
 		}()
+	}
 
-		app.logger.Info("starting webhook " + webhook)
+	var mx sync.Mutex
 
-		wh, _ := tg.NewWebhook(webhook)
-		if _, err := app.bot.Request(wh); err != nil {
-			return nil, err
-		}
+	reload := func() {
+		mx.Lock()
+		defer mx.Unlock()
 
-		info, err := app.bot.GetWebhookInfo()
-		if err != nil {
-			return nil, err
-		}
+		reloadConfig(conf, apps)
+	}
 
-		if info.LastErrorDate != 0 {
-			app.logger.Info(fmt.Sprintf("error %d %s", info.LastErrorDate, info.LastErrorMessage))
-		}
+	if err := conf.Watch(reload); err != nil {
+		slog.Error("can't watch config", "error", err.Error())
+	}
 
-		//if info.LastErrorDate != 0 {
-		//	app.logger.Errorf("Telegram callback failed: %s", info.LastErrorMessage)
-		//	return nil, fmt.Errorf(info.LastErrorMessage)
-		//}
+	done := make(chan struct{})
 
-		return app.bot.ListenForWebhook(app.config.String("webhook.path")), nil
-	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
 
-	app.logger.Info("start polling")
-	app.removeWebhook()
-	u := tg.NewUpdate(0)
-	u.Timeout = 60
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	return app.bot.GetUpdatesChan(u), nil
-}
+	for {
+		select {
+		case <-done:
+			for _, app := range apps {
+				app.quit()
+			}
 
-func (app *App) removeWebhook() {
-	if _, err := app.bot.Request(tg.WebhookConfig{URL: nil}); err != nil {
-		app.logger.Error("remove webhook error", "error", err)
+			return
+		case sig := <-sigc:
+			if sig == syscall.SIGHUP {
+				reload()
+				continue
+			}
+
+			slog.Info("quit")
+
+			for _, app := range apps {
+				app.quit()
+			}
+
+			return
+		}
 	}
 }
 
 func (app *App) quit() {
-	app.bot.StopReceivingUpdates()
+	app.transport.Stop()
+	app.users.Stop()
 }
 
-func (app *App) initCommands() error {
+func (app *App) initCommands() []*Command {
 	commands := []*Command{
 		{
 			key:  "start",
 			desc: "start",
 			cb:   app.start,
 		},
+		{
+			key:  "me",
+			desc: "My profile",
+			cb:   app.me,
+		},
 		{
 			key:  "callsign",
 			desc: "Change callsign",
 			cb:   app.callsign,
 		},
+		{
+			key:  "scope",
+			desc: "Join or leave scope",
+			cb:   app.scope,
+		},
 		{
 			key:  "team",
 			desc: "Change team",
@@ -163,284 +269,546 @@ func (app *App) initCommands() error {
 			desc: "Change role",
 			cb:   app.role,
 		},
+		{
+			key:  "alt",
+			desc: "Set altitude",
+			cb:   app.alt,
+		},
+		{
+			key:  "pause",
+			desc: "Pause location sharing",
+			cb:   app.pause,
+		},
+		{
+			key:  "resume",
+			desc: "Resume location sharing",
+			cb:   app.resume,
+		},
+		{
+			key:  "privacy",
+			desc: "Privacy settings",
+			cb:   app.privacy,
+		},
+		{
+			key:  "nearby",
+			desc: "Who is around me",
+			cb:   app.nearby,
+		},
+		{
+			key:  "where",
+			desc: "Where is callsign",
+			cb:   app.where,
+		},
+		{
+			key:  "map",
+			desc: "Map of units around me",
+			cb:   app.showMap,
+		},
+		{
+			key:  "status",
+			desc: "Set status text",
+			cb:   app.status,
+		},
+		{
+			key:  "cancel",
+			desc: "Cancel current question",
+			cb:   app.cancel,
+		},
+		{
+			key:  "audit",
+			desc: "Audit log (admins)",
+			cb:   app.audit,
+		},
 	}
 
-	tgCommands := make([]tg.BotCommand, 0, len(commands))
 	for _, cmd := range commands {
 		app.commands[cmd.key] = cmd
-		tgCommands = append(tgCommands, tg.BotCommand{
-			Command:     "/" + cmd.key,
-			Description: cmd.desc,
-		})
 	}
 
-	config := tg.NewSetMyCommands(tgCommands...)
-	_, err := app.bot.Request(config)
-
-	return err
+	return commands
 }
 
 func (app *App) Run() {
-	var err error
-
-	app.bot, err = tg.NewBotAPI(app.config.String("token"))
+	if err := app.users.Start(); err != nil {
+		panic(err)
+	}
 
-	if err != nil || app.bot == nil {
-		panic("can't start bot " + err.Error())
+	messages, err := app.transport.Start(app.initCommands())
+	if err != nil {
+		panic(err)
 	}
 
-	app.logger.Info("registering " + app.bot.Self.String())
+	if app.config.String("aprs.server") != "" {
+		go app.aprsReader()
+	}
 
-	updates, err := app.GetUpdatesChannel()
-	if err != nil {
-		app.logger.Error("error getting channel", "error", err.Error())
-		return
+	if app.config.String("meshtastic.broker") != "" {
+		go app.meshtasticReader()
 	}
 
-	if err := app.initCommands(); err != nil {
-		app.logger.Error("error on init commands", "error", err.Error())
-		return
+	if app.config.String("tak.server") != "" {
+		go app.takReader()
 	}
 
-	if err := app.users.Start(); err != nil {
-		panic(err)
+	if app.missions != nil {
+		go app.missions.Run()
 	}
 
-	sigc := make(chan os.Signal, 1)
-	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	go app.retry.Run(app.send)
 
-	for {
-		select {
-		case update := <-updates:
-			go app.Process(update)
-		case <-sigc:
-			app.logger.Info("quit")
-			app.quit()
-			return
-		}
+	for msg := range messages {
+		go app.Process(msg)
 	}
+
+	app.logger.Info("transport closed")
 }
 
-func (app *App) Process(update tg.Update) {
-	if cq := update.CallbackQuery; cq != nil {
-		user := app.users.Get(
-			fmt.Sprintf("%d", cq.From.ID),
-			getLogin(cq.From),
-			fmt.Sprintf("tg-%s", getName(cq.From)),
-		)
+func (app *App) Process(msg *InMessage) {
+	user := app.users.Get(msg.UserID, msg.Login, app.uidPrefix()+msg.Name)
+	logger := app.logger.With("id", msg.UserID, "name", msg.Login)
 
-		txt := cq.Data
-		app.logger.Info("callback with data " + txt)
-		tokens := strings.SplitN(txt, "_", 2)
+	var answer *OutMessage
+	switch msg.Kind {
+	case MsgCallback:
+		logger.Info("callback with data " + msg.Text)
+		tokens := strings.SplitN(msg.Text, "_", 2)
 
 		if len(tokens) != 2 {
-			app.logger.Warn("invalid callback data: " + txt)
+			logger.Warn("invalid callback data: " + msg.Text)
 			return
 		}
 
 		if cb, ok := app.callbacks[tokens[0]]; ok {
-			msg, err := cb(cq, user, tokens[1])
+			var err error
+			answer, err = cb(msg, user, tokens[1])
+			if err != nil {
+				logger.Error("callback error", "error", err.Error())
+				return
+			}
+		}
+	case MsgCommand:
+		if cmd, ok := app.commands[msg.Command]; ok {
+			// new command drops unfinished dialog
+			if msg.Command != "cancel" {
+				_ = app.states.Delete(user.Id)
+			}
+
+			var err error
+			answer, err = cmd.cb(msg, user)
 			if err != nil {
-				app.logger.Error("callback error", "error", err.Error())
+				logger.Error(fmt.Sprintf("error in command %s: %s", msg.Command, err.Error()))
 				return
 			}
-			app.sendMsg(msg)
 		}
+	case MsgLocation:
+		app.processLocation(user, msg.Login, app.transport.Name(), msg.Location)
+	default:
+		var ok bool
+		var err error
+
+		answer, ok, err = app.continueDialog(msg, user, strings.TrimSpace(msg.Text))
+		if err != nil {
+			logger.Error("dialog error", "error", err.Error())
+			return
+		}
+
+		if !ok {
+			logger.Info("message: " + msg.Text)
+		}
+	}
+
+	if err := app.sendMsg(answer); err != nil {
+		logger.Error("answer send error", "error", err)
+	}
+}
+
+// processLocation handles new position of the user from any source: transport name, aprs or meshtastic
+func (app *App) processLocation(user *database.UserInfo, login, source string, loc *Location) {
+	app.logger.Info(fmt.Sprintf("location: %f %f %f", loc.Lat, loc.Lon, loc.Accuracy), "id", user.Id, "name", login)
 
+	if !app.sharingAllowed(user, loc) {
+		app.logger.Debug("sharing is off", "id", user.Id)
 		return
 	}
 
-	var message *tg.Message
+	app.updateSpeed(user.Id, loc)
+	app.fillAltitude(user, loc)
+	blur(user, loc)
 
-	if update.EditedMessage != nil {
-		message = update.EditedMessage
-	} else {
-		message = update.Message
+	app.users.UpdatePos(user.Id, login, loc.Lat, loc.Lon)
+	app.checkFences(user, loc)
+
+	if app.cotEnabled() {
+		app.sendCotMessage(app.makeCot(user, source, app.config.Duration("cot.stale"), loc))
+		app.missions.AddUser(user)
 	}
+}
 
-	if message == nil {
-		app.logger.Warn("no message")
+type fix struct {
+	lat  float64
+	lon  float64
+	time time.Time
+}
+
+// updateSpeed calculates speed from previous position if source does not provide it
+func (app *App) updateSpeed(id string, loc *Location) {
+	now := time.Now()
+	prev, ok := app.fixes.Swap(id, fix{lat: loc.Lat, lon: loc.Lon, time: now})
+
+	if !ok || loc.Speed > 0 {
 		return
 	}
 
-	user := app.users.Get(
-		fmt.Sprintf("%d", message.From.ID),
-		getLogin(message.From),
-		fmt.Sprintf("tg-%s", getName(message.From)),
-	)
-	logger := app.logger.With("id", message.From.ID, "name", message.From.UserName)
+	if p := prev.(fix); now.Sub(p.time) > time.Second && now.Sub(p.time) < time.Minute*5 {
+		loc.Speed = distance(p.lat, p.lon, loc.Lat, loc.Lon) / now.Sub(p.time).Seconds()
+	}
+}
 
-	var answer tg.Chattable
+// fillAltitude sets altitude from user settings or ground elevation if source has no altitude
+func (app *App) fillAltitude(user *database.UserInfo, loc *Location) {
 	switch {
-	case message.IsCommand():
-		command := message.Command()
-		if cmd, ok := app.commands[command]; ok {
-			var err error
-			answer, err = cmd.cb(&update, user)
-			if err != nil {
-				logger.Error(fmt.Sprintf("error in command %s: %s", message.Text, err.Error()))
-				return
-			}
-		}
-	case message.Location != nil:
-		loc := message.Location
-		logger.Info(fmt.Sprintf("location: %f %f %f", loc.Latitude, loc.Longitude, loc.HorizontalAccuracy))
-		app.users.UpdatePos(user.Id, getLogin(message.From))
-		if app.config.String("cot.server") != "" {
-			app.sendCotMessage(app.makeCot(
-				user,
-				app.config.Duration("cot.stale"),
-				loc.Latitude,
-				loc.Longitude,
-				loc.HorizontalAccuracy,
-				float64(loc.Heading)),
-			)
-		}
+	case loc.AltSrc != "":
+	case user.Alt != nil:
+		loc.Alt = *user.Alt
+		loc.AltSrc = "USER"
 	default:
-		logger.Info("message: " + message.Text)
+		h, ok := app.elevation.Ground(loc.Lat, loc.Lon)
+		if !ok {
+			return
+		}
+
+		loc.Alt = h
+		loc.AltSrc = "SRTM"
 	}
 
-	if err := app.sendMsg(answer); err != nil {
-		logger.Error("answer send error", "error", err)
+	if loc.Le == 0 {
+		loc.Le = app.config.Float64("altitude.le." + strings.ToLower(loc.AltSrc))
 	}
 }
 
-func (app *App) sendMsg(msg tg.Chattable) error {
-	if msg == nil {
-		return nil
+// externalUser finds user for sender from non-messenger source (radio etc.)
+// using mapping from config section, nil if sender is not allowed
+func (app *App) externalUser(section, sender, name string) *database.UserInfo {
+	// section is one of radioSources
+	if id, ok := app.config.StringMap(section + ".users")[sender]; ok {
+		// do not rename known user after radio callsign
+		if u := app.users.Find(id); u != nil {
+			return u
+		}
+
+		return app.users.Get(id, "", name)
 	}
 
-	if _, err := app.bot.Send(msg); err != nil {
-		app.logger.Error("can't send message", "error", err.Error())
-		return err
+	if app.config.Bool(section + ".only_known") {
+		return nil
 	}
 
-	return nil
+	return app.users.Get(section+"-"+sender, "", name)
 }
 
-func (app *App) request(msg tg.Chattable) error {
+func (app *App) sendMsg(msg *OutMessage) error {
 	if msg == nil {
 		return nil
 	}
 
-	if _, err := app.bot.Request(msg); err != nil {
-		app.logger.Error("can't send request", "error", err.Error())
-		return err
-	}
-
-	return nil
+	return app.transport.Send(msg)
 }
 
-func (app *App) makeCot(user *database.UserInfo, d time.Duration, lat, lon, acc, heading float64) *cot.CotMessage {
-	scope := cmp.Or(user.Scope, app.defaultScope)
+// makeCot makes position event of the user, source is shown as takv platform
+func (app *App) makeCot(user *database.UserInfo, source string, d time.Duration, loc *Location) *cot.CotMessage {
+	scope := app.userScope(user)
 
-	evt := cot.BasicMsg(user.CotType, "tg-"+user.Id, d)
+	evt := cot.BasicMsg(user.CotType, app.userUid(user), d)
 	evt.CotEvent.How = "a-g"
-	evt.CotEvent.Lon = lon
-	evt.CotEvent.Lat = lat
-	evt.CotEvent.Ce = acc
+	evt.CotEvent.Lon = loc.Lon
+	evt.CotEvent.Lat = loc.Lat
 	evt.CotEvent.Access = scope
 
+	if loc.Accuracy > 0 {
+		evt.CotEvent.Ce = loc.Accuracy
+	}
+
+	altSrc := "???"
+	if loc.AltSrc != "" {
+		altSrc = loc.AltSrc
+		evt.CotEvent.Hae = loc.Alt + app.elevation.Undulation(loc.Lat, loc.Lon)
+
+		if loc.Le > 0 {
+			evt.CotEvent.Le = loc.Le
+		}
+	}
+
 	evt.CotEvent.Detail = &cotproto.Detail{
 		Contact:           &cotproto.Contact{Callsign: user.Callsign},
-		PrecisionLocation: &cotproto.PrecisionLocation{Geopointsrc: "GPS"},
-		Track:             &cotproto.Track{Course: heading},
+		PrecisionLocation: &cotproto.PrecisionLocation{Geopointsrc: "GPS", Altsrc: altSrc},
+		Track:             &cotproto.Track{Course: loc.Heading, Speed: loc.Speed},
+		Takv: &cotproto.Takv{
+			Device:   "cotobot",
+			Platform: sourcePlatform(source),
+			Os:       source,
+			Version:  getVersion(),
+		},
+	}
+
+	if loc.Battery > 0 {
+		evt.CotEvent.Detail.Status = &cotproto.Status{Battery: uint32(loc.Battery)}
 	}
 
 	if user.Team != "" {
 		evt.CotEvent.Detail.Group = &cotproto.Group{Name: user.Team, Role: user.Role}
 	}
 
-	return &cot.CotMessage{TakMessage: evt, Scope: scope}
+	xd := app.scopeDetails(scope)
+
+	if user.Status != "" {
+		xd.AddChild("remarks", nil, user.Status)
+	}
+
+	evt.CotEvent.Detail.XmlDetail = xd.AsXMLString()
+
+	return &cot.CotMessage{TakMessage: evt, Scope: scope, Detail: xd}
 }
 
-func (app *App) sendCotMessage(msg *cot.CotMessage) {
-	if app.config.String("cot.server") == "" {
-		return
+// userScope returns effective scope of the user
+func (app *App) userScope(user *database.UserInfo) string {
+	return cmp.Or(user.Scope, app.defaultScope)
+}
+
+// scopeFilter returns db scope values for scope, users with empty scope are in default one
+func (app *App) scopeFilter(scope string) []string {
+	if scope == app.defaultScope {
+		return []string{scope, ""}
 	}
 
-	if app.config.String("cot.proto") == "http" {
-		if err := app.sendHttp(msg); err != nil {
-			app.logger.Error("http send error", "error", err.Error())
+	return []string{scope}
+}
+
+// scopeDetails returns static xml details for scope from config
+func (app *App) scopeDetails(scope string) *cot.Node {
+	prefix := "scopes." + scope + "."
+
+	xd := cot.NewXMLDetails()
+
+	if s := app.config.String(prefix + "detail"); s != "" {
+		if d, err := cot.DetailsFromString(s); err == nil {
+			xd.Nodes = append(xd.Nodes, d.Nodes...)
+		} else {
+			app.logger.Error("invalid detail for scope "+scope, "error", err.Error())
 		}
-	} else {
-		app.sendTcp(msg)
 	}
+
+	if parent := app.config.String(prefix + "parent"); parent != "" {
+		xd.AddPpLink(parent, app.config.String(prefix+"parent_type"), app.config.String(prefix+"parent_callsign"))
+	}
+
+	return xd
 }
 
-func (app *App) sendTcp(msg *cot.CotMessage) {
-	data, err := proto.Marshal(msg.TakMessage)
-	if err != nil {
-		app.logger.Error("marshal error", "error", err)
+// cotEnabled checks if cot output has any destination
+func (app *App) cotEnabled() bool {
+	if app.udp != nil {
+		return app.udp.group != nil || len(app.udp.peers) > 0
+	}
+
+	return app.config.String("cot.server") != ""
+}
+
+func (app *App) sendCotMessage(msg *cot.CotMessage) {
+	if !app.cotEnabled() {
+		return
+	}
+
+	if err := app.send(msg); err != nil {
+		app.logger.Error("cot send error", "proto", app.config.String("cot.proto"), "error", err.Error())
+		app.retry.Add(msg)
+
 		return
 	}
 
-	fulldata := make([]byte, len(data)+3)
-	copy(fulldata[3:], data)
-	fulldata[0] = magicByte
-	fulldata[1] = 1
-	fulldata[2] = magicByte
+	app.retry.Sent(msg)
+}
+
+func (app *App) send(msg *cot.CotMessage) error {
+	switch app.config.String("cot.proto") {
+	case "http":
+		return app.http.Send(msg)
+	case "multicast":
+		return app.udp.Send(msg, app.config.String("cot.format"))
+	default:
+		return app.sendTcp(msg)
+	}
+}
+
+func (app *App) sendTcp(msg *cot.CotMessage) error {
+	fulldata, err := encodeCot(msg, app.config.String("cot.format"))
+	if err != nil {
+		return err
+	}
 
 	conn, err := net.Dial(app.config.String("cot.proto"), app.config.String("cot.server"))
 	if err != nil {
-		app.logger.Error("connection error", "error", err)
-		return
+		return err
 	}
 
 	defer conn.Close()
 
 	_ = conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
-	if _, err := conn.Write(fulldata); err != nil {
-		app.logger.Error("write error", "error", err)
+	_, err = conn.Write(fulldata)
+
+	return err
+}
+
+func openDatabase(conf *AppConfig) (*gorm.DB, error) {
+	db, err := database.GetDatabase(conf.String("database"), conf.Bool("db.debug"))
+	if err != nil {
+		return nil, err
 	}
+
+	err = database.SetPool(db, database.Pool{
+		MaxOpen:     conf.Int("db.max_open"),
+		MaxIdle:     conf.Int("db.max_idle"),
+		MaxLifetime: conf.Duration("db.max_lifetime"),
+		MaxIdleTime: conf.Duration("db.max_idle_time"),
+	})
+
+	return db, err
 }
 
-func (app *App) sendHttp(msg *cot.CotMessage) error {
-	cl := http.Client{
-		Timeout: time.Second * 5,
+// printMigrations shows migrations status, it doesn't create or change database
+func printMigrations(conf *AppConfig) error {
+	var db *gorm.DB
+
+	// opening sqlite creates the file
+	f := database.SqliteFile(conf.String("database"))
+	if _, err := os.Stat(f); f == "" || !errors.Is(err, os.ErrNotExist) {
+		if db, err = openDatabase(conf); err != nil {
+			return err
+		}
 	}
 
-	data, err := json.Marshal(msg)
+	states, err := database.MigrationStatus(db)
 	if err != nil {
 		return err
 	}
 
-	_, err = cl.Post(app.config.String("cot.server"), "application/json", bytes.NewReader(data))
-	return err
+	if !slices.ContainsFunc(states, func(st database.MigrationState) bool { return st.AppliedAt != nil }) {
+		fmt.Println("no migrations applied")
+	}
+
+	for _, st := range states {
+		applied := "pending"
+		if st.AppliedAt != nil {
+			applied = st.AppliedAt.Format(time.DateTime)
+		}
+
+		fmt.Printf("%4d %-30s %s\n", st.Version, st.Name, applied)
+	}
+
+	return nil
 }
 
-func getName(u *tg.User) string {
-	if u == nil {
-		return ""
+// exportAudit writes audit records of all bot instances as csv
+func exportAudit(conf *AppConfig, since time.Duration) error {
+	db, err := openDatabase(conf)
+	if err != nil {
+		return err
 	}
 
-	switch {
-	case u.UserName != "":
-		return u.UserName
-	case u.LastName != "" || u.FirstName != "":
-		return strings.Trim(u.FirstName+" "+u.LastName, " \t\n\r")
-	default:
-		return fmt.Sprintf("%d", u.ID)
+	q := database.NewAuditQuery(db).Order("at").Limit(0)
+	if since > 0 {
+		q.Since(time.Now().Add(-since))
 	}
-}
 
-func getLogin(u *tg.User) string {
-	if u == nil {
-		return ""
+	w := csv.NewWriter(os.Stdout)
+	_ = w.Write([]string{"time", "tenant", "actor", "target", "field", "old", "new"})
+
+	for _, r := range q.Get() {
+		_ = w.Write([]string{r.At.Format(time.RFC3339), r.Tenant, r.Actor, r.Target, r.Field, r.OldValue, r.NewValue})
 	}
 
-	return u.UserName
+	w.Flush()
+
+	return w.Error()
 }
 
 func main() {
+	checkConfig := flag.Bool("check-config", false, "check config and exit")
+	dbStatus := flag.Bool("db-status", false, "show database migrations status and exit")
+	auditExport := flag.Bool("audit-export", false, "write audit log as csv to stdout and exit")
+	auditSince := flag.Duration("audit-since", 0, "export only audit records newer than this, e.g. 24h")
+	flag.Parse()
+
 	conf := NewAppConfig()
 	conf.Load("cotobot.yml")
 	_ = conf.LoadEnv("BOT_")
 
+	if *auditExport {
+		if err := exportAudit(conf, *auditSince); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if *dbStatus {
+		if err := printMigrations(conf); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if *checkConfig {
+		errs := conf.Validate()
+		for _, e := range errs {
+			fmt.Println(e.String())
+		}
+
+		if len(errs) > 0 {
+			fmt.Printf("%d config errors\n", len(errs))
+			os.Exit(1)
+		}
+
+		fmt.Println("config ok")
+
+		return
+	}
+
 	h := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo})
 	slog.SetDefault(slog.New(h))
 
 	slog.Default().Info("starting app version " + getVersion())
 
-	NewApp(conf).Run()
+	if errs := conf.Validate(); len(errs) > 0 {
+		for _, e := range errs {
+			slog.Error("config error", "key", e.Key, "error", e.Msg)
+		}
+
+		os.Exit(1)
+	}
+
+	db, err := openDatabase(conf)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := database.Migrate(db); err != nil {
+		panic(err)
+	}
+
+	apps := make([]*App, 0, len(conf.Tenants()))
+	listen := false
+
+	for _, tc := range conf.Tenants() {
+		apps = append(apps, NewApp(tc, db))
+		listen = listen || tc.String("webhook.ext") != ""
+	}
+
+	if listen {
+		startListener(conf)
+	}
+
+	runApps(conf, apps)
 }
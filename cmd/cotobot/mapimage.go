@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	mapSize  = 512
+	tileSize = 256
+)
+
+type mapPoint struct {
+	lat   float64
+	lon   float64
+	label string
+	color color.Color
+}
+
+var teamColors = map[string]color.RGBA{
+	"White":      {255, 255, 255, 255},
+	"Yellow":     {255, 255, 0, 255},
+	"Orange":     {255, 119, 0, 255},
+	"Magenta":    {255, 0, 255, 255},
+	"Red":        {255, 0, 0, 255},
+	"Maroon":     {127, 0, 0, 255},
+	"Purple":     {127, 0, 127, 255},
+	"Dark Blue":  {0, 0, 160, 255},
+	"Blue":       {0, 0, 255, 255},
+	"Cyan":       {0, 255, 255, 255},
+	"Teal":       {0, 127, 127, 255},
+	"Green":      {0, 200, 0, 255},
+	"Dark Green": {0, 100, 0, 255},
+	"Brown":      {160, 82, 45, 255},
+}
+
+func teamColor(team string) color.Color {
+	if c, ok := teamColors[team]; ok {
+		return c
+	}
+
+	return color.RGBA{0, 0, 0, 255}
+}
+
+// renderMap draws points around center on tiles from offline cache or on plain sketch with distance rings
+func renderMap(lat, lon, radius float64, tiles string, points []mapPoint) ([]byte, error) {
+	z := mapZoom(lat, radius)
+	cx, cy := project(lat, lon, z)
+	x0, y0 := cx-mapSize/2, cy-mapSize/2
+
+	img := image.NewRGBA(image.Rect(0, 0, mapSize, mapSize))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{240, 240, 230, 255}), image.Point{}, draw.Src)
+
+	if tiles == "" || !drawTiles(img, tiles, z, x0, y0) {
+		drawRings(img, lat, radius, z)
+	}
+
+	for _, p := range points {
+		px, py := project(p.lat, p.lon, z)
+		x, y := int(px-x0), int(py-y0)
+
+		drawDot(img, x, y, 5, color.Black)
+		drawDot(img, x, y, 4, p.color)
+		drawLabel(img, x+7, y+4, p.label)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// mapZoom returns max zoom level radius fits the image on
+func mapZoom(lat, radius float64) int {
+	for z := 18; z > 0; z-- {
+		mpp := 156543.03 * math.Cos(toRad(lat)) / math.Pow(2, float64(z))
+		if 2*radius/mpp <= mapSize*0.9 {
+			return z
+		}
+	}
+
+	return 1
+}
+
+// project returns web mercator pixel coordinates
+func project(lat, lon float64, z int) (float64, float64) {
+	n := math.Pow(2, float64(z)) * tileSize
+	x := (lon + 180) / 360 * n
+	y := (1 - math.Log(math.Tan(toRad(lat))+1/math.Cos(toRad(lat)))/math.Pi) / 2 * n
+
+	return x, y
+}
+
+// drawTiles draws tiles from {z}/{x}/{y} file pattern, returns false if no tile found
+func drawTiles(img *image.RGBA, pattern string, z int, x0, y0 float64) bool {
+	found := false
+
+	for tx := int(x0) / tileSize; tx <= int(x0+mapSize)/tileSize; tx++ {
+		for ty := int(y0) / tileSize; ty <= int(y0+mapSize)/tileSize; ty++ {
+			r := strings.NewReplacer("{z}", strconv.Itoa(z), "{x}", strconv.Itoa(tx), "{y}", strconv.Itoa(ty))
+
+			f, err := os.Open(r.Replace(pattern))
+			if err != nil {
+				continue
+			}
+
+			tile, _, err := image.Decode(f)
+			f.Close()
+
+			if err != nil {
+				continue
+			}
+
+			pos := image.Pt(tx*tileSize-int(x0), ty*tileSize-int(y0))
+			draw.Draw(img, tile.Bounds().Add(pos), tile, tile.Bounds().Min, draw.Src)
+			found = true
+		}
+	}
+
+	return found
+}
+
+// drawRings draws distance rings for sketch map
+func drawRings(img *image.RGBA, lat, radius float64, z int) {
+	mpp := 156543.03 * math.Cos(toRad(lat)) / math.Pow(2, float64(z))
+	grey := color.RGBA{160, 160, 160, 255}
+
+	for i := 1; i <= 4; i++ {
+		d := radius * float64(i) / 4
+		r := d / mpp
+
+		for a := 0.0; a < 2*math.Pi; a += 1 / r {
+			img.Set(mapSize/2+int(r*math.Cos(a)), mapSize/2+int(r*math.Sin(a)), grey)
+		}
+
+		drawLabel(img, mapSize/2+2, mapSize/2-int(r)-2, formatDistance(d))
+	}
+
+	for i := 0; i < mapSize; i++ {
+		img.Set(i, mapSize/2, grey)
+		img.Set(mapSize/2, i, grey)
+	}
+
+	drawLabel(img, mapSize/2+4, 12, "N")
+}
+
+func drawDot(img *image.RGBA, x, y, r int, c color.Color) {
+	for dx := -r; dx <= r; dx++ {
+		for dy := -r; dy <= r; dy++ {
+			if dx*dx+dy*dy <= r*r {
+				img.Set(x+dx, y+dy, c)
+			}
+		}
+	}
+}
+
+func drawLabel(img *image.RGBA, x, y int, s string) {
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(color.Black),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x, y),
+	}
+
+	d.DrawString(s)
+}
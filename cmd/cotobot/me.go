@@ -0,0 +1,173 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"cotobot/cmd/cotobot/database"
+)
+
+// unitTypes are cot types user can choose in profile
+var unitTypes = []struct {
+	cotType string
+	name    string
+}{
+	{"a-f-G", "Ground unit"},
+	{"a-f-G-U-C-I", "Infantry"},
+	{"a-f-G-U-C-R", "Recon"},
+	{"a-f-G-U-S-M", "Medical"},
+	{"a-f-G-E-V", "Vehicle"},
+	{"a-f-A", "Aircraft"},
+}
+
+func unitTypeName(cotType string) string {
+	for _, t := range unitTypes {
+		if t.cotType == cotType {
+			return t.name
+		}
+	}
+
+	return cotType
+}
+
+func isUnitType(cotType string) bool {
+	for _, t := range unitTypes {
+		if t.cotType == cotType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (app *App) me(msg *InMessage, user *database.UserInfo) (*OutMessage, error) {
+	answer := msg.Reply(app.profileCard(user))
+	answer.Keyboard = profileKeyboard(user)
+
+	return answer, nil
+}
+
+// callbackMe handles profile card buttons, "field" shows choices, "field:value" sets value.
+// Card message is edited in place.
+func (app *App) callbackMe(msg *InMessage, user *database.UserInfo, data string) (*OutMessage, error) {
+	field, val, set := strings.Cut(data, ":")
+
+	answer := msg.Reply("")
+	answer.EditID = msg.MessageID
+
+	back := Button{Text: "« back", Data: "me_back"}
+
+	switch field {
+	case "callsign":
+		if err := app.states.Save(&database.DialogState{UserId: user.Id, Name: "callsign", Step: "enter"}); err != nil {
+			return nil, err
+		}
+
+		answer.Text = fmt.Sprintf("callsign: %s\nsend new callsign, /cancel to stop", user.Callsign)
+		answer.Keyboard = makeKeyboard(1, back)
+
+		return answer, nil
+	case "team":
+		if !set {
+			answer.Text = "select team"
+			answer.Keyboard = makeKeyboard(3, append(choiceButtons("me_team:", app.config.Strings("teams")), back)...)
+
+			return answer, nil
+		}
+
+		if slices.Contains(app.config.Strings("teams"), val) {
+			app.setTeam(user, val)
+		}
+	case "role":
+		if !set {
+			answer.Text = "select role"
+			answer.Keyboard = makeKeyboard(2, append(choiceButtons("me_role:", app.config.Strings("roles")), back)...)
+
+			return answer, nil
+		}
+
+		if slices.Contains(app.config.Strings("roles"), val) {
+			app.setRole(user, val)
+		}
+	case "type":
+		if !set {
+			buttons := make([]Button, 0, len(unitTypes)+1)
+			for _, t := range unitTypes {
+				buttons = append(buttons, Button{Text: t.name, Data: "me_type:" + t.cotType})
+			}
+
+			answer.Text = "select unit type"
+			answer.Keyboard = makeKeyboard(2, append(buttons, back)...)
+
+			return answer, nil
+		}
+
+		if !isUnitType(val) {
+			break
+		}
+
+		if val != user.CotType {
+			app.logger.Info(fmt.Sprintf("%s type %s -> %s", user.Id, user.CotType, val))
+			user.CotType = val
+			app.users.Save(user)
+		}
+	case "share":
+		app.setPaused(user, !user.Paused)
+	}
+
+	answer.Text = app.profileCard(user)
+	answer.Keyboard = profileKeyboard(user)
+
+	return answer, nil
+}
+
+func (app *App) profileCard(user *database.UserInfo) string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("callsign: %s\n", user.Callsign))
+	sb.WriteString(fmt.Sprintf("team: %s\n", cmp.Or(user.Team, NO_TEAM)))
+	sb.WriteString(fmt.Sprintf("role: %s\n", user.Role))
+	sb.WriteString(fmt.Sprintf("type: %s\n", unitTypeName(user.CotType)))
+	sb.WriteString(fmt.Sprintf("scope: %s\n", app.userScope(user)))
+
+	if user.LastPos != nil {
+		sb.WriteString(fmt.Sprintf("last position: %s ago\n", formatAge(time.Since(*user.LastPos))))
+	} else {
+		sb.WriteString("last position: never\n")
+	}
+
+	if user.Paused {
+		sb.WriteString("sharing: paused")
+	} else {
+		sb.WriteString("sharing: on")
+	}
+
+	return sb.String()
+}
+
+func profileKeyboard(user *database.UserInfo) [][]Button {
+	share := Button{Text: "pause sharing", Data: "me_share"}
+	if user.Paused {
+		share = Button{Text: "resume sharing", Data: "me_share"}
+	}
+
+	return makeKeyboard(2,
+		Button{Text: "callsign", Data: "me_callsign"},
+		Button{Text: "team", Data: "me_team"},
+		Button{Text: "role", Data: "me_role"},
+		Button{Text: "type", Data: "me_type"},
+		share,
+	)
+}
+
+func choiceButtons(prefix string, choices []string) []Button {
+	buttons := make([]Button, 0, len(choices))
+	for _, c := range choices {
+		buttons = append(buttons, Button{Text: c, Data: prefix + c})
+	}
+
+	return buttons
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// meshMessage is a message from meshtastic json mqtt uplink
+type meshMessage struct {
+	From    uint32          `json:"from"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type meshPosition struct {
+	LatitudeI  int32 `json:"latitude_i"`
+	LongitudeI int32 `json:"longitude_i"`
+	Altitude   int32 `json:"altitude"`
+}
+
+type meshTelemetry struct {
+	BatteryLevel int `json:"battery_level"`
+}
+
+type meshNodeInfo struct {
+	LongName  string `json:"longname"`
+	ShortName string `json:"shortname"`
+}
+
+// parseMeshPosition parses position payload, integer coordinates are in 1e-7 degrees
+func parseMeshPosition(payload json.RawMessage) (*Location, error) {
+	var pos meshPosition
+	if err := json.Unmarshal(payload, &pos); err != nil {
+		return nil, err
+	}
+
+	if pos.LatitudeI == 0 && pos.LongitudeI == 0 {
+		return nil, errNoPosition
+	}
+
+	loc := &Location{
+		Lat: float64(pos.LatitudeI) * 1e-7,
+		Lon: float64(pos.LongitudeI) * 1e-7,
+	}
+
+	if loc.Lat < -90 || loc.Lat > 90 || loc.Lon < -180 || loc.Lon > 180 {
+		return nil, fmt.Errorf("invalid position %f %f", loc.Lat, loc.Lon)
+	}
+
+	if pos.Altitude != 0 {
+		loc.Alt = float64(pos.Altitude)
+		loc.AltSrc = "GPS"
+	}
+
+	return loc, nil
+}
+
+// meshtasticReader subscribes to meshtastic json topic on mqtt broker and sends node positions to TAK
+func (app *App) meshtasticReader() {
+	logger := app.logger.With("logger", "meshtastic")
+
+	// node names from nodeinfo packets and battery levels from telemetry
+	names := sync.Map{}
+	battery := sync.Map{}
+
+	handler := func(_ mqtt.Client, m mqtt.Message) {
+		var msg meshMessage
+		if err := json.Unmarshal(m.Payload(), &msg); err != nil {
+			logger.Debug("invalid message on "+m.Topic(), "error", err.Error())
+			return
+		}
+
+		node := fmt.Sprintf("!%08x", msg.From)
+
+		switch msg.Type {
+		case "nodeinfo":
+			var info meshNodeInfo
+			if err := json.Unmarshal(msg.Payload, &info); err == nil && info.LongName != "" {
+				names.Store(node, info.LongName)
+			}
+		case "telemetry":
+			var t meshTelemetry
+			if err := json.Unmarshal(msg.Payload, &t); err == nil && t.BatteryLevel > 0 {
+				battery.Store(node, min(t.BatteryLevel, 100))
+			}
+		case "position":
+			loc, err := parseMeshPosition(msg.Payload)
+			if err != nil {
+				logger.Debug("invalid position from "+node, "error", err.Error())
+				return
+			}
+
+			name := node
+			if n, ok := names.Load(node); ok {
+				name = n.(string)
+			}
+
+			user := app.externalUser("meshtastic", node, name)
+			if user == nil {
+				return
+			}
+
+			if b, ok := battery.Load(node); ok {
+				loc.Battery = b.(int)
+			}
+
+			app.processLocation(user, user.Login, "meshtastic", loc)
+		}
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(app.config.String("meshtastic.broker")).
+		SetClientID(fmt.Sprintf("cotobot-%d", time.Now().Unix())).
+		SetUsername(app.config.String("meshtastic.user")).
+		SetPassword(app.config.String("meshtastic.password")).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			topic := app.config.String("meshtastic.topic")
+			logger.Info("connected, subscribe to " + topic)
+
+			if t := c.Subscribe(topic, 0, handler); t.Wait() && t.Error() != nil {
+				logger.Error("subscribe error", "error", t.Error().Error())
+			}
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logger.Warn("connection lost", "error", err.Error())
+		})
+
+	client := mqtt.NewClient(opts)
+
+	for {
+		if t := client.Connect(); t.Wait() && t.Error() != nil {
+			logger.Error("mqtt connection error", "error", t.Error().Error())
+			time.Sleep(time.Second * 30)
+
+			continue
+		}
+
+		return
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseMeshPosition(t *testing.T) {
+	// payload of meshtastic json mqtt uplink
+	var msg meshMessage
+	if err := json.Unmarshal([]byte(`{"channel":0,"from":2712847316,"id":1,"payload":{"altitude":150,
+		"latitude_i":557512345,"longitude_i":-376187654,"precision_bits":32,"time":1700000000},
+		"sender":"!a1b2c3d4","timestamp":1700000001,"to":4294967295,"type":"position"}`), &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.From != 0xa1b2c3d4 || msg.Type != "position" {
+		t.Fatalf("message %+v", msg)
+	}
+
+	loc, err := parseMeshPosition(msg.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !near(loc.Lat, 55.7512345, 1e-9) || !near(loc.Lon, -37.6187654, 1e-9) || loc.Alt != 150 || loc.AltSrc != "GPS" {
+		t.Errorf("location %+v", loc)
+	}
+
+	for payload, noPos := range map[string]bool{
+		`{"time":1700000000}`:                         true,
+		`{"latitude_i":0,"longitude_i":0}`:            true,
+		`{"latitude_i":1900000000,"longitude_i":10}`:  false,
+		`{"latitude_i":"557512345","longitude_i":10}`: false,
+	} {
+		loc, err := parseMeshPosition(json.RawMessage(payload))
+		if err == nil || errors.Is(err, errNoPosition) != noPos {
+			t.Errorf("%s: %+v %v", payload, loc, err)
+		}
+	}
+}
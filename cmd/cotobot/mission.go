@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cotobot/cmd/cotobot/database"
+)
+
+const missionCreator = "cotobot"
+
+// MissionBinding binds users of scope (and team) to TAK mission
+type MissionBinding struct {
+	Scope string   `koanf:"scope"`
+	Team  string   `koanf:"team"`
+	Name  string   `koanf:"name"`
+	Chats []string `koanf:"chats"`
+}
+
+func (b *MissionBinding) Match(scope, team string) bool {
+	return b.Scope == scope && (b.Team == "" || b.Team == team)
+}
+
+type MissionChange struct {
+	Type        string `json:"type"`
+	MissionName string `json:"missionName"`
+	Timestamp   string `json:"timestamp"`
+	CreatorUid  string `json:"creatorUid"`
+	ContentUid  string `json:"contentUid"`
+	Details     *struct {
+		Type     string `json:"type"`
+		Callsign string `json:"callsign"`
+	} `json:"details"`
+}
+
+// MissionClient is a client for TAK server Mission API
+type MissionClient struct {
+	url    string
+	token  string
+	user   string
+	pass   string
+	client *http.Client
+}
+
+func NewMissionClient(conf *AppConfig) (*MissionClient, error) {
+	tlsConf := &tls.Config{InsecureSkipVerify: conf.Bool("mission.insecure")} //nolint:gosec
+
+	if ca := conf.String("mission.ca"); ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid ca file %s", ca)
+		}
+
+		tlsConf.RootCAs = pool
+	}
+
+	if cert, key := conf.String("mission.cert"), conf.String("mission.key"); cert != "" && key != "" {
+		c, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConf.Certificates = []tls.Certificate{c}
+	}
+
+	return &MissionClient{
+		url:   strings.TrimSuffix(conf.String("mission.url"), "/"),
+		token: conf.String("mission.token"),
+		user:  conf.String("mission.user"),
+		pass:  conf.String("mission.password"),
+		client: &http.Client{
+			Timeout:   time.Second * 10,
+			Transport: &http.Transport{TLSClientConfig: tlsConf},
+		},
+	}, nil
+}
+
+func (m *MissionClient) do(method, path string, params url.Values, body any, res any) error {
+	var r io.Reader
+
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		r = bytes.NewReader(b)
+	}
+
+	u := m.url + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	switch {
+	case m.token != "":
+		req.Header.Set("Authorization", "Bearer "+m.token)
+	case m.user != "":
+		req.SetBasicAuth(m.user, m.pass)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: status %d %s", method, path, resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+
+	if res == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+// AddContents adds cot uids to mission
+func (m *MissionClient) AddContents(name string, uids ...string) error {
+	return m.do(http.MethodPut, "/Marti/api/missions/"+url.PathEscape(name)+"/contents",
+		url.Values{"creatorUid": {missionCreator}},
+		map[string]any{"uids": uids}, nil)
+}
+
+// Changes returns mission changes for last period
+func (m *MissionClient) Changes(name string, since time.Duration) ([]*MissionChange, error) {
+	var res struct {
+		Data []*MissionChange `json:"data"`
+	}
+
+	err := m.do(http.MethodGet, "/Marti/api/missions/"+url.PathEscape(name)+"/changes",
+		url.Values{"secago": {fmt.Sprintf("%d", int(since.Seconds()))}}, nil, &res)
+
+	return res.Data, err
+}
+
+// missionSync adds users' tracks to missions and polls mission changes to notify chats
+type missionSync struct {
+	app      *App
+	client   *MissionClient
+	bindings []*MissionBinding
+	added    sync.Map
+	seen     sync.Map
+}
+
+func newMissionSync(app *App) (*missionSync, error) {
+	var bindings []*MissionBinding
+
+	if err := app.config.Unmarshal("mission.bindings", &bindings); err != nil {
+		return nil, err
+	}
+
+	for _, b := range bindings {
+		if b.Scope == "" || b.Name == "" {
+			return nil, fmt.Errorf("mission binding requires scope and name")
+		}
+	}
+
+	client, err := NewMissionClient(app.config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &missionSync{app: app, client: client, bindings: bindings}, nil
+}
+
+// AddUser adds user's track to all missions user's scope and team are bound to
+func (ms *missionSync) AddUser(user *database.UserInfo) {
+	if ms == nil {
+		return
+	}
+
+	ms.AddUid(ms.app.userUid(user), ms.app.userScope(user), user.Team)
+}
+
+// AddUid adds cot uid to bound missions once
+func (ms *missionSync) AddUid(uid, scope, team string) {
+	if ms == nil {
+		return
+	}
+
+	for _, b := range ms.bindings {
+		if !b.Match(scope, team) {
+			continue
+		}
+
+		key := b.Name + "/" + uid
+		if _, loaded := ms.added.LoadOrStore(key, true); loaded {
+			continue
+		}
+
+		if err := ms.client.AddContents(b.Name, uid); err != nil {
+			ms.app.logger.Error("can't add to mission "+b.Name, "uid", uid, "error", err.Error())
+			ms.added.Delete(key)
+		}
+	}
+}
+
+func (ms *missionSync) Run() {
+	period := ms.app.config.Duration("mission.poll")
+
+	for range time.Tick(period) {
+		ms.cleanup()
+
+		for _, b := range ms.bindings {
+			if len(b.Chats) == 0 {
+				continue
+			}
+
+			// overlap to not lose changes between polls
+			changes, err := ms.client.Changes(b.Name, period*2)
+			if err != nil {
+				ms.app.logger.Error("mission changes error", "mission", b.Name, "error", err.Error())
+				continue
+			}
+
+			for _, c := range changes {
+				ms.notify(b, c)
+			}
+		}
+	}
+}
+
+func (ms *missionSync) notify(b *MissionBinding, c *MissionChange) {
+	key := fmt.Sprintf("%s/%s/%s/%s", b.Name, c.Timestamp, c.Type, c.ContentUid)
+	if _, loaded := ms.seen.LoadOrStore(key, time.Now()); loaded {
+		return
+	}
+
+	// own changes
+	if c.CreatorUid == missionCreator {
+		return
+	}
+
+	text := fmt.Sprintf("mission %s: %s", b.Name, strings.ToLower(strings.ReplaceAll(c.Type, "_", " ")))
+	if c.Details != nil && c.Details.Callsign != "" {
+		text += " " + c.Details.Callsign
+	} else if c.ContentUid != "" {
+		text += " " + c.ContentUid
+	}
+
+	for _, chat := range b.Chats {
+		if err := ms.app.sendMsg(NewOutMessage(chat, text)); err != nil {
+			ms.app.logger.Error("can't send mission change to "+chat, "error", err.Error())
+		}
+	}
+}
+
+// cleanup forgets old seen changes
+func (ms *missionSync) cleanup() {
+	ms.seen.Range(func(k, v any) bool {
+		if time.Since(v.(time.Time)) > time.Hour {
+			ms.seen.Delete(k)
+		}
+
+		return true
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testTransport struct {
+	mx   sync.Mutex
+	sent []*OutMessage
+}
+
+func (t *testTransport) Name() string { return "test" }
+
+func (t *testTransport) Start(_ []*Command) (<-chan *InMessage, error) { return nil, nil }
+
+func (t *testTransport) Send(msg *OutMessage) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.sent = append(t.sent, msg)
+
+	return nil
+}
+
+func (t *testTransport) Stop() {}
+
+func newTestMissionClient(t *testing.T, h http.HandlerFunc) *MissionClient {
+	t.Helper()
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	conf := NewAppConfig()
+	conf.get().Set("mission.url", srv.URL+"/")
+	conf.get().Set("mission.token", "secret")
+
+	client, err := NewMissionClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func TestMissionClientAddContents(t *testing.T) {
+	var got struct {
+		method, path, creator, auth string
+		body                        map[string][]string
+	}
+
+	client := newTestMissionClient(t, func(w http.ResponseWriter, r *http.Request) {
+		got.method, got.path = r.Method, r.URL.EscapedPath()
+		got.creator = r.URL.Query().Get("creatorUid")
+		got.auth = r.Header.Get("Authorization")
+
+		if err := json.NewDecoder(r.Body).Decode(&got.body); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if err := client.AddContents("team one", "tg-1", "tg-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.method != http.MethodPut || got.path != "/Marti/api/missions/team%20one/contents" {
+		t.Errorf("request %s %s", got.method, got.path)
+	}
+
+	if got.creator != missionCreator {
+		t.Errorf("creatorUid %q", got.creator)
+	}
+
+	if got.auth != "Bearer secret" {
+		t.Errorf("authorization %q", got.auth)
+	}
+
+	if uids := got.body["uids"]; len(uids) != 2 || uids[0] != "tg-1" || uids[1] != "tg-2" {
+		t.Errorf("uids %v", uids)
+	}
+}
+
+func TestMissionClientChanges(t *testing.T) {
+	var secago string
+
+	client := newTestMissionClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/Marti/api/missions/alpha/changes" {
+			http.NotFound(w, r)
+			return
+		}
+
+		secago = r.URL.Query().Get("secago")
+
+		_, _ = w.Write([]byte(`{"data": [{"type": "ADD_CONTENT", "missionName": "alpha", "timestamp": "2024-01-01T00:00:00Z",
+			"creatorUid": "ANDROID-1", "contentUid": "u1", "details": {"type": "a-f-G", "callsign": "BRAVO"}}]}`))
+	})
+
+	changes, err := client.Changes("alpha", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if secago != "60" {
+		t.Errorf("secago %q", secago)
+	}
+
+	if len(changes) != 1 {
+		t.Fatalf("%d changes", len(changes))
+	}
+
+	if c := changes[0]; c.Type != "ADD_CONTENT" || c.ContentUid != "u1" || c.Details == nil || c.Details.Callsign != "BRAVO" {
+		t.Errorf("change %+v", c)
+	}
+
+	if _, err := client.Changes("bravo", time.Minute); err == nil {
+		t.Error("error expected on 404")
+	}
+}
+
+func TestMissionSyncNotify(t *testing.T) {
+	tr := &testTransport{}
+	ms := &missionSync{app: &App{transport: tr, logger: slog.Default()}}
+	b := &MissionBinding{Scope: "alpha", Name: "alpha", Chats: []string{"100"}}
+
+	c := &MissionChange{Type: "ADD_CONTENT", Timestamp: "2024-01-01T00:00:00Z", CreatorUid: "ANDROID-1", ContentUid: "u1"}
+	ms.notify(b, c)
+	ms.notify(b, c)
+
+	own := &MissionChange{Type: "ADD_CONTENT", Timestamp: "2024-01-01T00:00:01Z", CreatorUid: missionCreator, ContentUid: "tg-1"}
+	ms.notify(b, own)
+
+	if len(tr.sent) != 1 {
+		t.Fatalf("%d messages sent, 1 expected", len(tr.sent))
+	}
+
+	if m := tr.sent[0]; m.ChatID != "100" || m.Text != "mission alpha: add content u1" {
+		t.Errorf("message %+v", m)
+	}
+}
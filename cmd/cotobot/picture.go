@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kdudkov/goatak/pkg/cot"
+)
+
+// Item is a contact or marker from TAK server
+type Item struct {
+	Uid      string
+	Type     string
+	Callsign string
+	Team     string
+	Scope    string
+	Lat      float64
+	Lon      float64
+	Stale    time.Time
+	Updated  time.Time
+}
+
+// Picture is a live copy of situational picture received from TAK server
+type Picture struct {
+	mx    sync.RWMutex
+	items map[string]*Item
+}
+
+func NewPicture() *Picture {
+	return &Picture{items: make(map[string]*Item)}
+}
+
+func (p *Picture) Add(msg *cot.CotMessage) {
+	if msg == nil || !(msg.IsContact() || msg.IsMapItem()) {
+		return
+	}
+
+	lat, lon := msg.GetLatLon()
+	if lat == 0 && lon == 0 {
+		return
+	}
+
+	item := &Item{
+		Uid:      msg.GetUID(),
+		Type:     msg.GetType(),
+		Callsign: msg.GetCallsign(),
+		Team:     msg.GetTeam(),
+		Scope:    msg.GetTakMessage().GetCotEvent().GetAccess(),
+		Lat:      lat,
+		Lon:      lon,
+		Stale:    msg.GetStaleTime(),
+		Updated:  time.Now(),
+	}
+
+	p.mx.Lock()
+	p.items[item.Uid] = item
+	p.mx.Unlock()
+}
+
+func (p *Picture) Remove(uid string) {
+	p.mx.Lock()
+	delete(p.items, uid)
+	p.mx.Unlock()
+}
+
+// Cleanup removes stale items
+func (p *Picture) Cleanup() {
+	now := time.Now()
+
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	for k, v := range p.items {
+		if v.Stale.Before(now) {
+			delete(p.items, k)
+		}
+	}
+}
+
+// Find returns item by callsign, items without scope are visible for everyone
+func (p *Picture) Find(callsign, scope string) *Item {
+	p.mx.RLock()
+	defer p.mx.RUnlock()
+
+	for _, v := range p.items {
+		if strings.EqualFold(v.Callsign, callsign) && (v.Scope == "" || v.Scope == scope) {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// Around returns items in scope within radius meters from point
+func (p *Picture) Around(lat, lon, radius float64, scope string) []*Item {
+	p.mx.RLock()
+	defer p.mx.RUnlock()
+
+	var res []*Item
+
+	for _, v := range p.items {
+		if (v.Scope == "" || v.Scope == scope) && distance(lat, lon, v.Lat, v.Lon) <= radius {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
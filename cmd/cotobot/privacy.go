@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kdudkov/goatak/pkg/cot"
+
+	"cotobot/cmd/cotobot/database"
+)
+
+// sharingAllowed checks user privacy settings: pause, sharing window and scope area
+func (app *App) sharingAllowed(user *database.UserInfo, loc *Location) bool {
+	if user.Paused {
+		return false
+	}
+
+	if user.ShareFrom != "" && user.ShareTo != "" {
+		if !inWindow(time.Now().In(app.location()), user.ShareFrom, user.ShareTo) {
+			return false
+		}
+	}
+
+	if user.ShareInArea {
+		area := app.scopeArea(app.userScope(user))
+		if area == nil || !area.Contains(loc.Lat, loc.Lon) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// blur snaps position to grid of user.Grid meters
+func blur(user *database.UserInfo, loc *Location) {
+	if user.Grid <= 0 {
+		return
+	}
+
+	step := float64(user.Grid)
+	latStep := step / 111320
+	loc.Lat = math.Floor(loc.Lat/latStep)*latStep + latStep/2
+
+	// longitude step depends on snapped latitude only to keep grid fixed within cell row
+	lonStep := step / (111320 * math.Max(math.Cos(toRad(loc.Lat)), 0.01))
+	loc.Lon = math.Floor(loc.Lon/lonStep)*lonStep + lonStep/2
+	loc.Accuracy = math.Max(loc.Accuracy, step)
+	loc.Heading = 0
+	loc.Speed = 0
+}
+
+func (app *App) location() *time.Location {
+	if tz := app.config.String("timezone"); tz != "" {
+		if l, err := time.LoadLocation(tz); err == nil {
+			return l
+		}
+	}
+
+	return time.Local
+}
+
+// inWindow checks if time of day is between from and to (HH:MM), window may cross midnight
+func inWindow(t time.Time, from, to string) bool {
+	f, err1 := parseClock(from)
+	e, err2 := parseClock(to)
+
+	if err1 != nil || err2 != nil {
+		return true
+	}
+
+	now := t.Hour()*60 + t.Minute()
+
+	if f <= e {
+		return now >= f && now < e
+	}
+
+	return now >= f || now < e
+}
+
+// parseClock returns minutes from midnight for HH:MM
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid time %s", s)
+	}
+
+	hh, err := strconv.Atoi(h)
+	if err != nil || hh < 0 || hh > 24 {
+		return 0, fmt.Errorf("invalid time %s", s)
+	}
+
+	mm, err := strconv.Atoi(m)
+	if err != nil || mm < 0 || mm > 59 {
+		return 0, fmt.Errorf("invalid time %s", s)
+	}
+
+	return hh*60 + mm, nil
+}
+
+// sendOffline removes user's point from TAK map in scope
+func (app *App) sendOffline(user *database.UserInfo, scope string) {
+	msg := cot.MakeOfflineMsg(app.userUid(user), user.CotType)
+	msg.CotEvent.Access = scope
+
+	app.sendCotMessage(&cot.CotMessage{TakMessage: msg, Scope: scope})
+}
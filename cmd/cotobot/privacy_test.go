@@ -0,0 +1,26 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"cotobot/cmd/cotobot/database"
+)
+
+func TestBlurFixedGrid(t *testing.T) {
+	user := &database.UserInfo{Grid: 1000}
+
+	// both points are in the same latitude cell, but far enough for raw cos(lat) to differ
+	latStep := 1000.0 / 111320
+	row := math.Floor(60/latStep) * latStep
+
+	a := &Location{Lat: row + latStep*0.01, Lon: 30.0001}
+	b := &Location{Lat: row + latStep*0.99, Lon: 30.0001}
+
+	blur(user, a)
+	blur(user, b)
+
+	if a.Lat != b.Lat || a.Lon != b.Lon {
+		t.Errorf("points of one cell snapped to different centers: %v %v and %v %v", a.Lat, a.Lon, b.Lat, b.Lon)
+	}
+}
@@ -1,67 +1,464 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
+	"math"
+	"reflect"
+	"sync"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"cotobot/cmd/cotobot/database"
 )
 
+// ActorTelegram is audit actor for changes made after telegram profile
+const ActorTelegram = "telegram"
+
 type UserManager struct {
 	logger      *slog.Logger
 	db          *gorm.DB
+	tenant      string
 	defaultType string
+	cacheTTL    time.Duration
+	flushEvery  time.Duration
+	mx          sync.Mutex
+	flushMx     sync.Mutex
+	cache       map[string]*cacheEntry
+	pending     map[string]*posUpdate
+	flushing    map[string]*posUpdate
+	stop        chan struct{}
+	// callsign returns valid callsign made of user name, empty if there is none
+	callsign func(u *database.UserInfo, name string) string
+}
+
+type cacheEntry struct {
+	user   *database.UserInfo
+	loaded time.Time
+}
+
+// posUpdate is last position not written to db yet
+type posUpdate struct {
+	login string
+	lat   float64
+	lon   float64
+	t     time.Time
+}
+
+func (p *posUpdate) apply(u *database.UserInfo) {
+	u.Login = p.login
+	u.Lat = p.lat
+	u.Lon = p.lon
+	u.LastPos = &p.t
 }
 
-func NewUserManager(db *gorm.DB) *UserManager {
+// NewUserManager returns users storage with write-through cache. Cached users are reloaded after cacheTTL
+// to see changes made by other processes, positions are written in batches every flushEvery.
+func NewUserManager(db *gorm.DB, tenant string, cacheTTL, flushEvery time.Duration) *UserManager {
 	um := &UserManager{
-		logger:      slog.Default().With("logger", "UserManager"),
+		logger:      slog.Default().With("logger", "UserManager", "tenant", tenant),
 		db:          db,
+		tenant:      tenant,
 		defaultType: "a-f-G",
+		cacheTTL:    cacheTTL,
+		flushEvery:  flushEvery,
+		cache:       make(map[string]*cacheEntry),
+		pending:     make(map[string]*posUpdate),
+		stop:        make(chan struct{}),
 	}
 
 	return um
 }
 
+// query returns users query limited to bot instance
+func (um *UserManager) query() *database.UserQuery {
+	return database.NewUserQuery(um.db).Tenant(um.tenant)
+}
+
+// load returns copy of cached user, loads it from db if not cached or expired
+func (um *UserManager) load(id string) *database.UserInfo {
+	um.mx.Lock()
+	if e, ok := um.cache[id]; ok && time.Since(e.loaded) < um.cacheTTL {
+		u := *e.user
+		um.mx.Unlock()
+
+		return &u
+	}
+	um.mx.Unlock()
+
+	u := um.query().ID(id).One()
+	if u == nil {
+		return nil
+	}
+
+	um.mx.Lock()
+	defer um.mx.Unlock()
+
+	// positions being written are still not visible in db
+	if p, ok := um.flushing[id]; ok {
+		p.apply(u)
+	}
+
+	if p, ok := um.pending[id]; ok {
+		p.apply(u)
+	}
+
+	um.cache[id] = &cacheEntry{user: u, loaded: time.Now()}
+	res := *u
+
+	return &res
+}
+
+// forget drops user from cache, next access reloads it from db
+func (um *UserManager) forget(id string) {
+	um.mx.Lock()
+	delete(um.cache, id)
+	um.mx.Unlock()
+}
+
+// Get returns user, creates new one on first contact. Login and name are refreshed,
+// callsign follows name change while user keeps default one and new name makes valid callsign.
 func (um *UserManager) Get(id, login, name string) *database.UserInfo {
-	if u := database.NewUserQuery(um.db).ID(id).One(); u != nil {
+	u := um.load(id)
+
+	if u == nil {
+		now := time.Now()
+		u = &database.UserInfo{
+			Tenant:    um.tenant,
+			Id:        id,
+			Login:     login,
+			Callsign:  name,
+			Name:      name,
+			Role:      "Team Member",
+			Scope:     "",
+			CotType:   um.defaultType,
+			FirstSeen: &now,
+		}
+
+		if cs := um.nameCallsign(u, name); cs != "" {
+			u.Callsign = cs
+		}
+
+		um.logger.Info(fmt.Sprintf("new user %s %s", id, name))
+		um.save(u, id)
+
 		return u
 	}
 
-	return &database.UserInfo{
-		Id:       id,
-		Login:    login,
-		Callsign: name,
-		Role:     "Team Member",
-		Scope:    "",
-		CotType:  um.defaultType,
+	changed := false
+
+	if login != "" && login != u.Login {
+		u.Login = login
+		changed = true
 	}
+
+	if name != "" && name != u.Name {
+		if u.Callsign == u.Name {
+			if cs := um.nameCallsign(u, name); cs != "" {
+				u.Callsign = cs
+			}
+		}
+
+		u.Name = name
+		changed = true
+	}
+
+	if changed {
+		um.save(u, ActorTelegram)
+	}
+
+	return u
+}
+
+func (um *UserManager) nameCallsign(u *database.UserInfo, name string) string {
+	if um.callsign == nil {
+		return name
+	}
+
+	return um.callsign(u, name)
+}
+
+// Find returns existing user or nil
+func (um *UserManager) Find(id string) *database.UserInfo {
+	return um.load(id)
+}
+
+// UpdatePos stores position in cache, it is written to db on next flush
+func (um *UserManager) UpdatePos(id string, login string, lat, lon float64) error {
+	p := &posUpdate{login: login, lat: lat, lon: lon, t: time.Now()}
+
+	um.mx.Lock()
+	defer um.mx.Unlock()
+
+	um.pending[id] = p
+
+	if e, ok := um.cache[id]; ok {
+		p.apply(e.user)
+	}
+
+	return nil
 }
 
-func (um *UserManager) UpdatePos(id string, login string) error {
-	return database.NewUserQuery(um.db).ID(id).Update(map[string]any{"login": login, "last_pos": time.Now()})
+func (um *UserManager) Update(id string, updates map[string]any) error {
+	defer um.forget(id)
+
+	return um.query().ID(id).Update(updates)
 }
 
+// Nearby returns users in scopes with fresh position within radius meters from point
+func (um *UserManager) Nearby(scopes []string, lat, lon, radius float64, since time.Time) []*database.UserInfo {
+	um.Flush()
+
+	dLat := toDeg(radius / earthRadius)
+	dLon := dLat / math.Max(math.Cos(toRad(lat)), 0.01)
+
+	users := um.query().
+		Scope(scopes...).
+		Active().
+		Since(since).
+		Bbox(lat-dLat, lon-dLon, lat+dLat, lon+dLon).
+		Limit(1000).
+		Get()
+
+	res := make([]*database.UserInfo, 0, len(users))
+	for _, u := range users {
+		if distance(lat, lon, u.Lat, u.Lon) <= radius {
+			res = append(res, u)
+		}
+	}
+
+	return res
+}
+
+// Save stores user changed by user himself
 func (um *UserManager) Save(u *database.UserInfo) error {
-	err := um.db.Save(u).Error
+	return um.save(u, u.Id)
+}
+
+// save stores fields changed by actor since user was loaded and writes audit records for them.
+// Other fields keep values from db, so changes made meanwhile by other paths are not reverted.
+func (um *UserManager) save(u *database.UserInfo, actor string) error {
+	u.Tenant = um.tenant
+
+	um.mx.Lock()
+	var base *database.UserInfo
+	if e, ok := um.cache[u.Id]; ok {
+		b := *e.user
+		base = &b
+	}
+	um.mx.Unlock()
+
+	err := um.db.Transaction(func(tx *gorm.DB) error {
+		old := database.NewUserQuery(tx).Tenant(um.tenant).ID(u.Id).One()
+		if old == nil {
+			return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(u).Error
+		}
+
+		if base == nil {
+			base = old
+		}
+
+		fields := changedFields(base, u)
+		if len(fields) == 0 {
+			*u = *old
+			return nil
+		}
+
+		merged := *old
+		mv, uv := reflect.ValueOf(&merged).Elem(), reflect.ValueOf(u).Elem()
+
+		for _, f := range fields {
+			mv.FieldByName(f).Set(uv.FieldByName(f))
+		}
+
+		for _, r := range auditRecords(old, &merged, actor) {
+			if err := tx.Create(r).Error; err != nil {
+				return err
+			}
+		}
+
+		*u = merged
+
+		return tx.Model(&database.UserInfo{}).Where("tenant = ? AND id = ?", um.tenant, u.Id).Select(fields).Updates(u).Error
+	})
 
 	if err != nil {
 		um.logger.Error("save error", slog.Any("error", err))
+		um.forget(u.Id)
+
+		return err
+	}
+
+	um.mx.Lock()
+	// positions being written are still not visible in db
+	if p, ok := um.flushing[u.Id]; ok {
+		p.apply(u)
+	}
+
+	if p, ok := um.pending[u.Id]; ok {
+		p.apply(u)
+	}
+
+	c := *u
+	um.cache[u.Id] = &cacheEntry{user: &c, loaded: time.Now()}
+	um.mx.Unlock()
+
+	return nil
+}
+
+// Audit returns last audit records of target user, of all users if id is empty
+func (um *UserManager) Audit(id string, limit int) []*database.AuditRecord {
+	return database.NewAuditQuery(um.db).Tenant(um.tenant).Target(id).Limit(limit).Get()
+}
+
+// CallsignTaken checks if other user in scopes has callsign
+func (um *UserManager) CallsignTaken(callsign string, scopes []string, exceptId string) bool {
+	for _, u := range um.query().Callsign(callsign).Scope(scopes...).Get() {
+		if u.Id != exceptId {
+			return true
+		}
 	}
 
-	return err
+	return false
 }
 
-func (um *UserManager) Start() error {
-	if err := um.db.AutoMigrate(&database.UserInfo{}); err != nil {
-		return err
+// FindCallsign returns user with callsign or nil
+func (um *UserManager) FindCallsign(callsign string) *database.UserInfo {
+	return um.query().Callsign(callsign).One()
+}
+
+// changedFields returns names of user fields that differ from base
+func changedFields(base, u *database.UserInfo) []string {
+	var res []string
+
+	bv, uv := reflect.ValueOf(base).Elem(), reflect.ValueOf(u).Elem()
+
+	for i := 0; i < bv.NumField(); i++ {
+		name := bv.Type().Field(i).Name
+		if name == "Tenant" || name == "Id" {
+			continue
+		}
+
+		if !reflect.DeepEqual(bv.Field(i).Interface(), uv.Field(i).Interface()) {
+			res = append(res, name)
+		}
+	}
+
+	return res
+}
+
+// auditRecords returns records for changed fields that are visible to others
+func auditRecords(old, u *database.UserInfo, actor string) []*database.AuditRecord {
+	var res []*database.AuditRecord
+
+	now := time.Now()
+
+	for _, f := range []struct{ field, old, new string }{
+		{"callsign", old.Callsign, u.Callsign},
+		{"team", old.Team, u.Team},
+		{"role", old.Role, u.Role},
+		{"type", old.CotType, u.CotType},
+		{"scope", old.Scope, u.Scope},
+	} {
+		if f.old != f.new {
+			res = append(res, &database.AuditRecord{
+				Tenant:   u.Tenant,
+				Actor:    actor,
+				Target:   u.Id,
+				Field:    f.field,
+				OldValue: f.old,
+				NewValue: f.new,
+				At:       now,
+			})
+		}
+	}
+
+	return res
+}
+
+// Flush writes pending positions to db and drops expired cache entries
+func (um *UserManager) Flush() {
+	um.flushMx.Lock()
+	defer um.flushMx.Unlock()
+
+	um.mx.Lock()
+	pending := um.pending
+	um.pending = make(map[string]*posUpdate)
+	um.flushing = pending
+
+	for id, e := range um.cache {
+		if time.Since(e.loaded) >= um.cacheTTL {
+			delete(um.cache, id)
+		}
+	}
+	um.mx.Unlock()
+
+	defer func() {
+		um.mx.Lock()
+		um.flushing = nil
+		um.mx.Unlock()
+	}()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	err := um.db.Transaction(func(tx *gorm.DB) error {
+		for id, p := range pending {
+			err := database.NewUserQuery(tx).Tenant(um.tenant).ID(id).
+				Update(map[string]any{"login": p.login, "last_pos": p.t, "lat": p.lat, "lon": p.lon})
+
+			if err != nil && !errors.Is(err, database.ErrNotFound) {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		um.logger.Error("position flush error", "error", err.Error(), "count", len(pending))
+
+		// return failed positions for next flush, newer ones came meanwhile win
+		um.mx.Lock()
+		for id, p := range pending {
+			if cur, ok := um.pending[id]; !ok || cur.t.Before(p.t) {
+				um.pending[id] = p
+			}
+		}
+		um.mx.Unlock()
 	}
+}
+
+func (um *UserManager) flushLoop() {
+	ticker := time.NewTicker(um.flushEvery)
+	defer ticker.Stop()
 
-	if database.NewUserQuery(um.db).Count() == 0 {
+	for {
+		select {
+		case <-um.stop:
+			return
+		case <-ticker.C:
+			um.Flush()
+		}
+	}
+}
+
+// Stop writes pending positions on shutdown
+func (um *UserManager) Stop() {
+	close(um.stop)
+	um.Flush()
+}
+
+func (um *UserManager) Start() error {
+	if um.query().Count() == 0 {
 		um.logger.Info("db is empty - load users files")
 	}
 
+	go um.flushLoop()
+
 	return nil
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cotobot/cmd/cotobot/database"
+)
+
+func newTestUsers(t *testing.T, tenant string) *UserManager {
+	t.Helper()
+
+	db, err := database.GetDatabase(filepath.Join(t.TempDir(), "test.sqlite"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewUserManager(db, tenant, time.Minute, time.Hour)
+}
+
+func TestUserManagerGetSave(t *testing.T) {
+	um := newTestUsers(t, "org1")
+
+	u := um.Get("1", "login1", "tg-one")
+	if u.Callsign != "tg-one" || u.FirstSeen == nil {
+		t.Fatalf("unexpected new user %+v", u)
+	}
+
+	if database.NewUserQuery(um.db).Tenant("org1").ID("1").One() == nil {
+		t.Fatal("new user is not saved")
+	}
+
+	u.Team = "Red"
+	if err := um.Save(u); err != nil {
+		t.Fatal(err)
+	}
+
+	um.forget("1")
+
+	if got := um.Get("1", "login1", "tg-one"); got.Team != "Red" {
+		t.Fatalf("team is not saved: %+v", got)
+	}
+
+	records := um.Audit("1", 10)
+	if len(records) != 1 || records[0].Field != "team" || records[0].NewValue != "Red" || records[0].Actor != "1" {
+		t.Fatalf("unexpected audit %+v", records)
+	}
+
+	other := NewUserManager(um.db, "org2", time.Minute, time.Hour)
+	if other.Find("1") != nil {
+		t.Fatal("user is visible in other tenant")
+	}
+}
+
+func TestUserManagerFlush(t *testing.T) {
+	um := newTestUsers(t, "")
+
+	um.Get("1", "", "tg-one")
+	_ = um.UpdatePos("1", "login1", 55.5, 37.5)
+
+	if u := um.Find("1"); u.Lat != 55.5 || u.LastPos == nil {
+		t.Fatalf("cached position is not updated: %+v", u)
+	}
+
+	um.Flush()
+
+	u := database.NewUserQuery(um.db).Tenant("").ID("1").One()
+	if u == nil || u.Lat != 55.5 || u.Lon != 37.5 || u.Login != "login1" {
+		t.Fatalf("position is not flushed: %+v", u)
+	}
+}
+
+func TestUserManagerFlushError(t *testing.T) {
+	um := newTestUsers(t, "")
+
+	um.Get("1", "", "tg-one")
+	_ = um.UpdatePos("1", "login1", 55.5, 37.5)
+
+	if err := um.db.Exec("ALTER TABLE user_infos RENAME TO user_infos_off").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	um.Flush()
+
+	if p := um.pending["1"]; p == nil || p.lat != 55.5 {
+		t.Fatalf("failed position is lost: %+v", p)
+	}
+
+	if err := um.db.Exec("ALTER TABLE user_infos_off RENAME TO user_infos").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	um.Flush()
+
+	if u := database.NewUserQuery(um.db).Tenant("").ID("1").One(); u == nil || u.Lat != 55.5 {
+		t.Fatalf("position is not flushed on retry: %+v", u)
+	}
+}
+
+func TestUserManagerNameCallsign(t *testing.T) {
+	um := newTestUsers(t, "")
+	um.callsign = func(_ *database.UserInfo, name string) string {
+		if name == "tg-taken" {
+			return ""
+		}
+
+		return strings.ReplaceAll(name, " ", "_")
+	}
+
+	if u := um.Get("1", "", "tg-John Smith"); u.Callsign != "tg-John_Smith" || u.Name != "tg-John Smith" {
+		t.Fatalf("invalid callsign of new user: %+v", u)
+	}
+
+	u := um.Get("2", "", "tg-two")
+	if u.Callsign != "tg-two" {
+		t.Fatalf("callsign %s", u.Callsign)
+	}
+
+	if u = um.Get("2", "", "tg-taken"); u.Callsign != "tg-two" || u.Name != "tg-taken" {
+		t.Fatalf("callsign follows name without valid callsign: %+v", u)
+	}
+}
+
+func TestUserManagerSaveKeepsOtherEdits(t *testing.T) {
+	um := newTestUsers(t, "")
+
+	u := um.Get("1", "", "tg-one")
+
+	if err := um.db.Exec("UPDATE user_infos SET scope = 'ops' WHERE id = '1'").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	u.Status = "on duty"
+	if err := um.Save(u); err != nil {
+		t.Fatal(err)
+	}
+
+	db := database.NewUserQuery(um.db).Tenant("").ID("1").One()
+	if db.Scope != "ops" || db.Status != "on duty" {
+		t.Fatalf("unexpected saved user: scope %q status %q", db.Scope, db.Status)
+	}
+
+	if u.Scope != "ops" {
+		t.Errorf("saved user is not refreshed, scope %q", u.Scope)
+	}
+
+	if recs := um.Audit("1", 10); len(recs) != 0 {
+		t.Errorf("unexpected audit records %+v", recs[0])
+	}
+}
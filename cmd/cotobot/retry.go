@@ -0,0 +1,156 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kdudkov/goatak/pkg/cot"
+)
+
+type retryItem struct {
+	msg     *cot.CotMessage
+	attempt int
+	added   time.Time
+	next    time.Time
+}
+
+// retryQueue keeps messages failed to send and resends them with exponential backoff until message is stale.
+// Only the newest message of uid is kept, older position must not be sent after newer one.
+type retryQueue struct {
+	mx       sync.Mutex
+	items    []*retryItem
+	sent     map[string]time.Time
+	size     int
+	attempts int
+	delay    time.Duration
+}
+
+func newRetryQueue(conf *AppConfig) *retryQueue {
+	return &retryQueue{
+		sent:     make(map[string]time.Time),
+		size:     conf.Int("cot.retry.queue"),
+		attempts: conf.Int("cot.retry.attempts"),
+		delay:    conf.Duration("cot.retry.delay"),
+	}
+}
+
+// Add puts failed message to queue instead of queued messages of same uid,
+// oldest message is dropped if queue is full. Zero queue size or attempts disables retry.
+func (q *retryQueue) Add(msg *cot.CotMessage) {
+	if q.attempts <= 0 || q.size <= 0 {
+		return
+	}
+
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	q.drop(msg.GetUID())
+
+	if len(q.items) >= q.size {
+		q.items = q.items[1:]
+	}
+
+	now := time.Now()
+	q.items = append(q.items, &retryItem{msg: msg, attempt: 1, added: now, next: now.Add(q.delay)})
+}
+
+// Sent drops queued messages of uid after newer one is sent
+func (q *retryQueue) Sent(msg *cot.CotMessage) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	q.drop(msg.GetUID())
+	q.sent[msg.GetUID()] = time.Now()
+}
+
+func (q *retryQueue) drop(uid string) {
+	n := 0
+
+	for _, item := range q.items {
+		if item.msg.GetUID() != uid {
+			q.items[n] = item
+			n++
+		}
+	}
+
+	q.items = q.items[:n]
+}
+
+// Run resends due messages with send function
+func (q *retryQueue) Run(send func(msg *cot.CotMessage) error) {
+	for range time.Tick(time.Second) {
+		for _, item := range q.due() {
+			if err := send(item.msg); err == nil {
+				q.mx.Lock()
+				q.sent[item.msg.GetUID()] = time.Now()
+				q.mx.Unlock()
+
+				continue
+			}
+
+			item.attempt++
+			if item.attempt > q.attempts {
+				continue
+			}
+
+			item.next = time.Now().Add(q.delay * time.Duration(1<<min(item.attempt-1, 10)))
+
+			q.mx.Lock()
+			if !q.superseded(item) {
+				q.items = append(q.items, item)
+			}
+			q.mx.Unlock()
+		}
+	}
+}
+
+// superseded checks if newer message of same uid is queued or sent
+func (q *retryQueue) superseded(item *retryItem) bool {
+	uid := item.msg.GetUID()
+
+	if t, ok := q.sent[uid]; ok && t.After(item.added) {
+		return true
+	}
+
+	for _, i := range q.items {
+		if i.msg.GetUID() == uid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// due takes messages ready for resend, stale and superseded ones are dropped
+func (q *retryQueue) due() []*retryItem {
+	now := time.Now()
+
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	var res []*retryItem
+
+	n := 0
+
+	for _, item := range q.items {
+		switch {
+		case item.msg.GetStaleTime().Before(now):
+		case item.next.Before(now):
+			res = append(res, item)
+		default:
+			q.items[n] = item
+			n++
+		}
+	}
+
+	q.items = q.items[:n]
+
+	// forget sent times older than any possible queued message
+	for uid, t := range q.sent {
+		if now.Sub(t) > q.delay*time.Duration(1<<min(q.attempts, 10)) {
+			delete(q.sent, uid)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"cotobot/cmd/cotobot/database"
+)
+
+func (app *App) scope(msg *InMessage, user *database.UserInfo) (*OutMessage, error) {
+	args := strings.Fields(msg.Text)
+
+	switch {
+	case len(args) == 0:
+		return msg.Reply(fmt.Sprintf("your scope: %s\njoin: /scope <name> <code>\nleave: /scope leave", app.userScope(user))), nil
+	case args[0] == "leave" || args[0] == app.defaultScope:
+		return msg.Reply(app.setScope(user, "")), nil
+	case app.config.String("scopes."+args[0]+".code") == "":
+		return msg.Reply("unknown scope " + args[0]), nil
+	case len(args) == 1:
+		st := &database.DialogState{UserId: user.Id, Name: "scope", Step: "code"}
+		st.SetValue("scope", args[0])
+
+		if err := app.states.Save(st); err != nil {
+			return nil, err
+		}
+
+		return msg.Reply("send join code for scope " + args[0] + "\n/cancel to stop"), nil
+	default:
+		return msg.Reply(app.joinScope(user, args[0], args[1])), nil
+	}
+}
+
+// dialogScope waits for join code
+func (app *App) dialogScope(msg *InMessage, user *database.UserInfo, st *database.DialogState, input string) (*OutMessage, string, error) {
+	if input == "" {
+		return msg.Reply("send join code or /cancel"), st.Step, nil
+	}
+
+	return msg.Reply(app.joinScope(user, st.Values()["scope"], input)), "", nil
+}
+
+// joinScope checks scope join code from config
+func (app *App) joinScope(user *database.UserInfo, scope, code string) string {
+	want := app.config.String("scopes." + scope + ".code")
+
+	if want == "" || subtle.ConstantTimeCompare([]byte(code), []byte(want)) != 1 {
+		app.logger.Warn(fmt.Sprintf("%s invalid join code for scope %s", user.Id, scope))
+		return "invalid code"
+	}
+
+	return app.setScope(user, scope)
+}
+
+// setScope moves user to scope, empty one is default. Old scope gets stale cot to remove user's point.
+func (app *App) setScope(user *database.UserInfo, scope string) string {
+	old := app.userScope(user)
+
+	if scope == app.defaultScope {
+		scope = ""
+	}
+
+	if scope != user.Scope {
+		target := *user
+		target.Scope = scope
+
+		if app.callsignTaken(&target, user.Callsign) {
+			res := "callsign " + user.Callsign + " is taken in scope " + app.userScope(&target)
+			if cs := app.suggestCallsign(&target, user.Callsign); cs != "" {
+				res += ", change it first, e.g. /callsign " + cs
+			}
+
+			return res
+		}
+
+		app.logger.Info(fmt.Sprintf("%s scope %s -> %s", user.Id, user.Scope, scope))
+		user.Scope = scope
+		app.users.Save(user)
+	}
+
+	now := app.userScope(user)
+
+	if now != old && user.LastPos != nil && !user.Paused && app.cotEnabled() {
+		app.sendOffline(user, old)
+	}
+
+	return "your scope: " + now
+}
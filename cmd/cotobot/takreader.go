@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"net"
+	"time"
+
+	"github.com/kdudkov/goatak/pkg/cot"
+)
+
+// takReader keeps connection to TAK server and collects received contacts and markers to picture
+func (app *App) takReader() {
+	logger := app.logger.With("logger", "tak_reader")
+
+	go func() {
+		for range time.Tick(time.Minute) {
+			app.picture.Cleanup()
+		}
+	}()
+
+	for {
+		if err := app.takConnect(); err != nil {
+			logger.Error("tak connection error", "error", err.Error())
+		}
+
+		time.Sleep(time.Second * 30)
+	}
+}
+
+func (app *App) takConnect() error {
+	logger := app.logger.With("logger", "tak_reader")
+
+	conn, err := net.DialTimeout("tcp", app.config.String("tak.server"), time.Second*10)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	logger.Info("connected to " + app.config.String("tak.server"))
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go app.takPing(conn, done)
+
+	r := bufio.NewReader(conn)
+
+	b, err := r.Peek(1)
+	if err != nil {
+		return err
+	}
+
+	if b[0] == magicByte {
+		return app.readProto(r)
+	}
+
+	return app.readXML(r)
+}
+
+// takPing sends pings to keep connection alive
+func (app *App) takPing(conn net.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second * 30)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			b, err := xml.Marshal(cot.ProtoToEvent(cot.MakePing("cotobot")))
+			if err != nil {
+				continue
+			}
+
+			_ = conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
+			if _, err := conn.Write(b); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (app *App) readProto(r *bufio.Reader) error {
+	for {
+		msg, err := cot.ReadProto(r)
+		if err != nil {
+			return err
+		}
+
+		c, err := cot.CotFromProto(msg, "", "")
+		if err != nil {
+			continue
+		}
+
+		app.takMessage(c)
+	}
+}
+
+func (app *App) readXML(r *bufio.Reader) error {
+	er := cot.NewTagReader(r)
+
+	for {
+		tag, data, err := er.ReadTag()
+		if err != nil {
+			return err
+		}
+
+		if tag != "event" {
+			continue
+		}
+
+		ev := new(cot.Event)
+		if err := xml.Unmarshal(data, ev); err != nil {
+			app.logger.Debug("xml decode error", "error", err.Error())
+			continue
+		}
+
+		c, err := cot.EventToProto(ev)
+		if err != nil {
+			continue
+		}
+
+		app.takMessage(c)
+	}
+}
+
+func (app *App) takMessage(msg *cot.CotMessage) {
+	switch {
+	case msg.GetType() == "t-x-d-d":
+		if uid, _ := msg.GetParent(); uid != "" {
+			app.picture.Remove(uid)
+		}
+	case msg.IsPing() || msg.IsControl():
+	default:
+		app.picture.Add(msg)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type TelegramTransport struct {
+	config *AppConfig
+	bot    *tg.BotAPI
+	logger *slog.Logger
+}
+
+func NewTelegramTransport(conf *AppConfig) *TelegramTransport {
+	return &TelegramTransport{
+		config: conf,
+		logger: slog.Default().With("logger", "telegram", "tenant", conf.Name()),
+	}
+}
+
+func (t *TelegramTransport) Name() string {
+	return "telegram"
+}
+
+func (t *TelegramTransport) Start(commands []*Command) (<-chan *InMessage, error) {
+	var err error
+
+	t.bot, err = tg.NewBotAPI(t.config.String("token"))
+	if err != nil {
+		return nil, fmt.Errorf("can't start bot: %w", err)
+	}
+
+	t.logger.Info("registering " + t.bot.Self.String())
+
+	updates, err := t.getUpdatesChannel()
+	if err != nil {
+		return nil, fmt.Errorf("can't get updates channel: %w", err)
+	}
+
+	if err := t.setCommands(commands); err != nil {
+		return nil, fmt.Errorf("error on init commands: %w", err)
+	}
+
+	ch := make(chan *InMessage, t.bot.Buffer)
+
+	go func() {
+		for update := range updates {
+			if msg := t.convert(update); msg != nil {
+				ch <- msg
+			}
+		}
+
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+func (t *TelegramTransport) Stop() {
+	if t.bot != nil {
+		t.bot.StopReceivingUpdates()
+	}
+}
+
+func (t *TelegramTransport) getUpdatesChannel() (tg.UpdatesChannel, error) {
+	if webhook := t.config.String("webhook.ext"); webhook != "" {
+		return t.startWebhook(webhook)
+	}
+
+	t.logger.Info("start polling")
+	t.removeWebhook()
+	u := tg.NewUpdate(0)
+	u.Timeout = 60
+
+	return t.bot.GetUpdatesChan(u), nil
+}
+
+func (t *TelegramTransport) removeWebhook() {
+	if _, err := t.bot.Request(tg.WebhookConfig{URL: nil}); err != nil {
+		t.logger.Error("remove webhook error", "error", err)
+	}
+}
+
+func (t *TelegramTransport) setCommands(commands []*Command) error {
+	tgCommands := make([]tg.BotCommand, 0, len(commands))
+	for _, cmd := range commands {
+		tgCommands = append(tgCommands, tg.BotCommand{
+			Command:     "/" + cmd.key,
+			Description: cmd.desc,
+		})
+	}
+
+	_, err := t.bot.Request(tg.NewSetMyCommands(tgCommands...))
+
+	return err
+}
+
+func (t *TelegramTransport) convert(update tg.Update) *InMessage {
+	if cq := update.CallbackQuery; cq != nil {
+		t.request(tg.NewCallback(cq.ID, ""))
+
+		msg := &InMessage{
+			Kind:   MsgCallback,
+			UserID: fmt.Sprintf("%d", cq.From.ID),
+			Login:  getLogin(cq.From),
+			Name:   getName(cq.From),
+			ChatID: fmt.Sprintf("%d", cq.From.ID),
+			Text:   cq.Data,
+		}
+
+		if cq.Message != nil {
+			msg.ChatID = fmt.Sprintf("%d", cq.Message.Chat.ID)
+			msg.MessageID = fmt.Sprintf("%d", cq.Message.MessageID)
+		}
+
+		return msg
+	}
+
+	var message *tg.Message
+
+	if update.EditedMessage != nil {
+		message = update.EditedMessage
+	} else {
+		message = update.Message
+	}
+
+	if message == nil || message.From == nil {
+		t.logger.Warn("no message")
+		return nil
+	}
+
+	msg := &InMessage{
+		Kind:      MsgText,
+		UserID:    fmt.Sprintf("%d", message.From.ID),
+		Login:     getLogin(message.From),
+		Name:      getName(message.From),
+		ChatID:    fmt.Sprintf("%d", message.Chat.ID),
+		Text:      message.Text,
+		MessageID: fmt.Sprintf("%d", message.MessageID),
+	}
+
+	switch {
+	case message.IsCommand():
+		msg.Kind = MsgCommand
+		msg.Command = message.Command()
+		msg.Text = message.CommandArguments()
+	case message.Location != nil:
+		msg.Kind = MsgLocation
+		msg.Location = &Location{
+			Lat:      message.Location.Latitude,
+			Lon:      message.Location.Longitude,
+			Accuracy: message.Location.HorizontalAccuracy,
+			Heading:  float64(message.Location.Heading),
+		}
+	}
+
+	return msg
+}
+
+func (t *TelegramTransport) Send(msg *OutMessage) error {
+	if msg == nil {
+		return nil
+	}
+
+	chatID, err := strconv.ParseInt(msg.ChatID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid chat id %s", msg.ChatID)
+	}
+
+	var m tg.Chattable
+
+	switch {
+	case msg.EditID != "":
+		msgID, err := strconv.Atoi(msg.EditID)
+		if err != nil {
+			return fmt.Errorf("invalid message id %s", msg.EditID)
+		}
+
+		if len(msg.Keyboard) > 0 {
+			m = tg.NewEditMessageTextAndMarkup(chatID, msgID, msg.Text, inlineKeyboard(msg.Keyboard))
+		} else {
+			m = tg.NewEditMessageText(chatID, msgID, msg.Text)
+		}
+	case msg.Venue != nil:
+		v := msg.Venue
+		m = tg.NewVenue(chatID, v.Title, v.Address, v.Lat, v.Lon)
+	case msg.Photo != nil:
+		p := tg.NewPhoto(chatID, tg.FileBytes{Name: "map.png", Bytes: msg.Photo})
+		p.Caption = msg.Text
+		m = p
+	default:
+		mc := tg.NewMessage(chatID, msg.Text)
+
+		switch {
+		case len(msg.Keyboard) > 0:
+			mc.ReplyMarkup = inlineKeyboard(msg.Keyboard)
+		case msg.RemoveKeyboard:
+			mc.ReplyMarkup = tg.NewRemoveKeyboard(false)
+		}
+
+		m = mc
+	}
+
+	if _, err := t.bot.Send(m); err != nil {
+		t.logger.Error("can't send message", "error", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (t *TelegramTransport) request(msg tg.Chattable) error {
+	if msg == nil {
+		return nil
+	}
+
+	if _, err := t.bot.Request(msg); err != nil {
+		t.logger.Error("can't send request", "error", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func inlineKeyboard(buttons [][]Button) tg.InlineKeyboardMarkup {
+	keyboard := make([][]tg.InlineKeyboardButton, 0, len(buttons))
+
+	for _, r := range buttons {
+		row := make([]tg.InlineKeyboardButton, 0, len(r))
+		for _, b := range r {
+			row = append(row, tg.NewInlineKeyboardButtonData(b.Text, b.Data))
+		}
+
+		keyboard = append(keyboard, row)
+	}
+
+	return tg.InlineKeyboardMarkup{InlineKeyboard: keyboard}
+}
+
+func getName(u *tg.User) string {
+	if u == nil {
+		return ""
+	}
+
+	switch {
+	case u.UserName != "":
+		return u.UserName
+	case u.LastName != "" || u.FirstName != "":
+		return strings.Trim(u.FirstName+" "+u.LastName, " \t\n\r")
+	default:
+		return fmt.Sprintf("%d", u.ID)
+	}
+}
+
+func getLogin(u *tg.User) string {
+	if u == nil {
+		return ""
+	}
+
+	return u.UserName
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strings"
+
+	"cotobot/cmd/cotobot/database"
+)
+
+// Transport is a messenger connection: source of user messages and destination for answers
+type Transport interface {
+	Name() string
+	Start(commands []*Command) (<-chan *InMessage, error)
+	Send(msg *OutMessage) error
+	Stop()
+}
+
+// uidPrefixes are short prefixes of transports for cot uids and default callsigns,
+// transport name is used if there is none
+var uidPrefixes = map[string]string{
+	"telegram": "tg",
+}
+
+// radioSources are position sources besides messenger, senders not mapped to bot users
+// get ids prefixed with source name
+var radioSources = []string{"aprs", "meshtastic"}
+
+// sourcePlatforms are takv platforms of position sources, "<source> bridge" is used if there is none
+var sourcePlatforms = map[string]string{
+	"telegram":   "Telegram bridge",
+	"aprs":       "APRS-IS bridge",
+	"meshtastic": "Meshtastic bridge",
+}
+
+// uidPrefix returns prefix of the transport for cot uids and default callsigns
+func (app *App) uidPrefix() string {
+	name := app.transport.Name()
+	if p, ok := uidPrefixes[name]; ok {
+		name = p
+	}
+
+	return name + "-"
+}
+
+// userUid returns cot uid of user's point, radio senders keep their source prefix
+func (app *App) userUid(user *database.UserInfo) string {
+	for _, src := range radioSources {
+		if strings.HasPrefix(user.Id, src+"-") {
+			return user.Id
+		}
+	}
+
+	return app.uidPrefix() + user.Id
+}
+
+// sourcePlatform returns takv platform for position source
+func sourcePlatform(source string) string {
+	if p, ok := sourcePlatforms[source]; ok {
+		return p
+	}
+
+	return source + " bridge"
+}
+
+type MsgKind int
+
+const (
+	MsgText MsgKind = iota
+	MsgCommand
+	MsgLocation
+	MsgCallback
+)
+
+type Location struct {
+	Lat      float64
+	Lon      float64
+	Accuracy float64
+	Heading  float64
+	// Speed in m/s, 0 if unknown
+	Speed float64
+	// Battery level in percents, 0 if unknown
+	Battery int
+	// Alt is altitude MSL in meters
+	Alt float64
+	// AltSrc is altitude source (GPS, USER, SRTM), empty if altitude is unknown
+	AltSrc string
+	// Le is altitude error in meters
+	Le float64
+}
+
+// InMessage is an incoming event from transport
+type InMessage struct {
+	Kind MsgKind
+	// UserID is unique user id within transport, bot instance has one transport
+	UserID string
+	Login  string
+	Name   string
+	ChatID string
+	// Text is message text, command arguments or callback data
+	Text     string
+	Command  string
+	Location *Location
+	// MessageID is id of the message callback button belongs to
+	MessageID string
+}
+
+type Button struct {
+	Text string
+	Data string
+}
+
+// Venue is a point to show on messenger map
+type Venue struct {
+	Lat     float64
+	Lon     float64
+	Title   string
+	Address string
+}
+
+// OutMessage is a transport-independent answer
+type OutMessage struct {
+	ChatID         string
+	Text           string
+	Keyboard       [][]Button
+	RemoveKeyboard bool
+	// Venue is sent instead of text if set
+	Venue *Venue
+	// Photo is png image sent with Text as caption
+	Photo []byte
+	// EditID is id of bot message to replace text and keyboard of instead of sending new one
+	EditID string
+}
+
+func NewOutMessage(chatID string, text string) *OutMessage {
+	return &OutMessage{ChatID: chatID, Text: text}
+}
+
+// Reply creates an answer to the chat message came from
+func (m *InMessage) Reply(text string) *OutMessage {
+	return NewOutMessage(m.ChatID, text)
+}
+
+// makeKeyboard splits buttons to rows of n
+func makeKeyboard(n int, buttons ...Button) [][]Button {
+	var keyboard [][]Button
+
+	for i := 0; i < len(buttons); i += n {
+		keyboard = append(keyboard, buttons[i:min(i+n, len(buttons))])
+	}
+
+	return keyboard
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"cotobot/cmd/cotobot/database"
+)
+
+func TestMakeCotSource(t *testing.T) {
+	app := &App{transport: &testTransport{}, config: NewAppConfig(), elevation: &Elevation{}, logger: slog.Default()}
+	loc := &Location{Lat: 55.75, Lon: 37.62}
+
+	for _, tc := range []struct {
+		user     *database.UserInfo
+		source   string
+		uid      string
+		platform string
+	}{
+		{user: &database.UserInfo{Id: "123"}, source: "test", uid: "test-123", platform: "test bridge"},
+		{user: &database.UserInfo{Id: "123"}, source: "aprs", uid: "test-123", platform: "APRS-IS bridge"},
+		{user: &database.UserInfo{Id: "aprs-N0CALL-9"}, source: "aprs", uid: "aprs-N0CALL-9", platform: "APRS-IS bridge"},
+		{user: &database.UserInfo{Id: "meshtastic-!a1b2c3d4"}, source: "meshtastic", uid: "meshtastic-!a1b2c3d4", platform: "Meshtastic bridge"},
+	} {
+		msg := app.makeCot(tc.user, tc.source, time.Minute, loc)
+
+		if uid := msg.GetUID(); uid != tc.uid {
+			t.Errorf("%s from %s: uid %s, %s expected", tc.user.Id, tc.source, uid, tc.uid)
+		}
+
+		takv := msg.GetTakMessage().GetCotEvent().GetDetail().GetTakv()
+		if takv.GetPlatform() != tc.platform || takv.GetOs() != tc.source {
+			t.Errorf("%s from %s: takv %s %s", tc.user.Id, tc.source, takv.GetPlatform(), takv.GetOs())
+		}
+	}
+}
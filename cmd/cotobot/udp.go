@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"golang.org/x/net/ipv4"
+
+	"github.com/kdudkov/goatak/pkg/cot"
+)
+
+// udpOutput sends SA messages to multicast group and optional unicast peers from one socket
+type udpOutput struct {
+	mx    sync.Mutex
+	conn  *ipv4.PacketConn
+	group *net.UDPAddr
+	peers []*net.UDPAddr
+}
+
+func newUdpOutput(conf *AppConfig) (*udpOutput, error) {
+	o := &udpOutput{}
+
+	if s := conf.String("cot.server"); s != "" {
+		addr, err := net.ResolveUDPAddr("udp4", s)
+		if err != nil {
+			return nil, err
+		}
+
+		if !addr.IP.IsMulticast() {
+			return nil, fmt.Errorf("%s is not a multicast address", s)
+		}
+
+		o.group = addr
+	}
+
+	for _, s := range conf.Strings("cot.unicast") {
+		addr, err := net.ResolveUDPAddr("udp4", s)
+		if err != nil {
+			return nil, err
+		}
+
+		o.peers = append(o.peers, addr)
+	}
+
+	c, err := net.ListenPacket("udp4", "0.0.0.0:0")
+	if err != nil {
+		return nil, err
+	}
+
+	o.conn = ipv4.NewPacketConn(c)
+
+	if name := conf.String("cot.interface"); name != "" {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+
+		if err := o.conn.SetMulticastInterface(ifi); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	if err := o.conn.SetMulticastTTL(conf.Int("cot.ttl")); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	if err := o.conn.SetMulticastLoopback(conf.Bool("cot.loopback")); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return o, nil
+}
+
+func (o *udpOutput) Send(msg *cot.CotMessage, format string) error {
+	data, err := encodeCot(msg, format)
+	if err != nil {
+		return err
+	}
+
+	o.mx.Lock()
+	defer o.mx.Unlock()
+
+	if o.group != nil {
+		if _, err := o.conn.WriteTo(data, nil, o.group); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range o.peers {
+		if _, err := o.conn.WriteTo(data, nil, p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var tokenRe = regexp.MustCompile(`^\d+:[\w-]{30,}$`)
+
+// Settings is typed view of config used for validation
+type Settings struct {
+	Token    string `koanf:"token"`
+	Scope    string `koanf:"scope"`
+	Database string `koanf:"database"`
+	Timezone string `koanf:"timezone"`
+	Users    struct {
+		CacheTTL time.Duration `koanf:"cache_ttl"`
+		Flush    time.Duration `koanf:"flush"`
+	} `koanf:"users"`
+	Db struct {
+		Debug       bool          `koanf:"debug"`
+		MaxOpen     int           `koanf:"max_open"`
+		MaxIdle     int           `koanf:"max_idle"`
+		MaxLifetime time.Duration `koanf:"max_lifetime"`
+		MaxIdleTime time.Duration `koanf:"max_idle_time"`
+	} `koanf:"db"`
+	Webhook struct {
+		Ext        string        `koanf:"ext"`
+		Path       string        `koanf:"path"`
+		Listen     string        `koanf:"listen"`
+		Secret     string        `koanf:"secret"`
+		Allow      []string      `koanf:"allow"`
+		Cert       string        `koanf:"cert"`
+		Key        string        `koanf:"key"`
+		CheckDelay time.Duration `koanf:"check_delay"`
+	} `koanf:"webhook"`
+	Cot struct {
+		Proto   string        `koanf:"proto"`
+		Server  string        `koanf:"server"`
+		Stale   time.Duration `koanf:"stale"`
+		Format  string        `koanf:"format"`
+		Ttl     int           `koanf:"ttl"`
+		Unicast []string      `koanf:"unicast"`
+		Http    struct {
+			Api     string        `koanf:"api"`
+			Timeout time.Duration `koanf:"timeout"`
+		} `koanf:"http"`
+		Retry struct {
+			Queue    int           `koanf:"queue"`
+			Attempts int           `koanf:"attempts"`
+			Delay    time.Duration `koanf:"delay"`
+		} `koanf:"retry"`
+	} `koanf:"cot"`
+	Aprs struct {
+		Server    string        `koanf:"server"`
+		Reconnect time.Duration `koanf:"reconnect"`
+		Filter    string        `koanf:"filter"`
+		OnlyKnown bool          `koanf:"only_known"`
+	} `koanf:"aprs"`
+	Meshtastic struct {
+		Broker string `koanf:"broker"`
+	} `koanf:"meshtastic"`
+	Tak struct {
+		Server string `koanf:"server"`
+	} `koanf:"tak"`
+	Mission struct {
+		Url  string        `koanf:"url"`
+		Poll time.Duration `koanf:"poll"`
+	} `koanf:"mission"`
+	Nearby struct {
+		Radius    float64       `koanf:"radius"`
+		MaxRadius float64       `koanf:"max_radius"`
+		MaxAge    time.Duration `koanf:"max_age"`
+	} `koanf:"nearby"`
+	Altitude struct {
+		Dem   string `koanf:"dem"`
+		Geoid string `koanf:"geoid"`
+	} `koanf:"altitude"`
+	Dialog struct {
+		Timeout time.Duration `koanf:"timeout"`
+	} `koanf:"dialog"`
+	Callsign struct {
+		MinLen  int    `koanf:"min_len"`
+		MaxLen  int    `koanf:"max_len"`
+		Pattern string `koanf:"pattern"`
+	} `koanf:"callsign"`
+	Teams []string `koanf:"teams"`
+	Roles []string `koanf:"roles"`
+}
+
+// ConfigError is a problem with config setting
+type ConfigError struct {
+	Key string
+	Msg string
+}
+
+func (e ConfigError) String() string {
+	return e.Key + ": " + e.Msg
+}
+
+type configReport []ConfigError
+
+func (r *configReport) add(key, format string, args ...any) {
+	*r = append(*r, ConfigError{Key: key, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (r *configReport) addr(key, val string) {
+	if _, port, err := net.SplitHostPort(val); err != nil || port == "" {
+		r.add(key, "invalid address %q, host:port expected", val)
+	}
+}
+
+func (r *configReport) url(key, val string, schemes ...string) {
+	u, err := url.Parse(val)
+	if err != nil || u.Host == "" || !slices.Contains(schemes, u.Scheme) {
+		r.add(key, "invalid url %q, %s expected", val, strings.Join(schemes, " or "))
+	}
+}
+
+func (r *configReport) file(key, val string) {
+	if val == "" {
+		return
+	}
+
+	if _, err := os.Stat(val); err != nil {
+		r.add(key, "file %s is not readable: %s", val, err.Error())
+	}
+}
+
+func (r *configReport) positive(key string, d time.Duration) {
+	if d <= 0 {
+		r.add(key, "positive duration expected, got %s", d)
+	}
+}
+
+var tenantRe = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// Validate checks config with all bot instances and returns list of problems found
+func (c *AppConfig) Validate() []ConfigError {
+	var r configReport
+
+	for _, err := range c.errors {
+		r.add("file", "%s", err.Error())
+	}
+
+	tenants := c.Tenants()
+	if !c.multi() {
+		return append(r, c.validate()...)
+	}
+
+	names := make(map[string]bool)
+	paths := make(map[string]bool)
+	bots := c.get().Slices("bots")
+
+	for i, t := range tenants {
+		key := fmt.Sprintf("bots[%d]", i)
+
+		// one listener is shared by all bots
+		for _, k := range []string{"webhook.listen", "webhook.cert", "webhook.key"} {
+			if i < len(bots) && bots[i].Exists(k) {
+				r.add(key+"."+k, "listener setting is global, set it in root config")
+			}
+		}
+
+		switch {
+		case !tenantRe.MatchString(t.name):
+			r.add(key+".name", "name is required and must contain only a-z, 0-9, _ and -")
+		case names[t.name]:
+			r.add(key+".name", "duplicate name %s", t.name)
+		}
+
+		names[t.name] = true
+
+		if t.String("webhook.ext") != "" {
+			path := t.String("webhook.path")
+			if paths[path] {
+				r.add(key+".webhook.path", "webhook path %s is used by other bot", path)
+			}
+
+			paths[path] = true
+		}
+
+		for _, e := range t.validate() {
+			r.add(key+"."+e.Key, "%s", e.Msg)
+		}
+	}
+
+	return r
+}
+
+// validate checks settings of single bot instance
+func (c *AppConfig) validate() []ConfigError {
+	var r configReport
+
+	var s Settings
+	if err := c.Unmarshal("", &s); err != nil {
+		r.add("config", "%s", err.Error())
+		return r
+	}
+
+	switch {
+	case s.Token == "":
+		r.add("token", "bot token is required")
+	case !tokenRe.MatchString(s.Token):
+		r.add("token", "invalid bot token format")
+	}
+
+	if s.Scope == "" {
+		r.add("scope", "default scope is required")
+	} else if c.String("scopes."+s.Scope+".code") != "" {
+		// "/scope <default>" means leave, so the code can never be used
+		r.add("scopes."+s.Scope+".code", "default scope can't have a code, users are in it without joining")
+	}
+
+	if s.Database == "" {
+		r.add("database", "database is required")
+	}
+
+	r.positive("users.cache_ttl", s.Users.CacheTTL)
+	r.positive("users.flush", s.Users.Flush)
+
+	if s.Db.MaxOpen < 0 || s.Db.MaxIdle < 0 || s.Db.MaxLifetime < 0 || s.Db.MaxIdleTime < 0 {
+		r.add("db", "pool settings must not be negative")
+	}
+
+	if s.Timezone != "" {
+		if _, err := time.LoadLocation(s.Timezone); err != nil {
+			r.add("timezone", "unknown timezone %s", s.Timezone)
+		}
+	}
+
+	if s.Webhook.Ext != "" {
+		r.url("webhook.ext", s.Webhook.Ext, "https")
+		r.addr("webhook.listen", s.Webhook.Listen)
+
+		if !strings.HasPrefix(s.Webhook.Path, "/") {
+			r.add("webhook.path", "path must start with /")
+		}
+
+		if _, err := parseNets(s.Webhook.Allow); err != nil {
+			r.add("webhook.allow", "%s", err.Error())
+		}
+
+		if (s.Webhook.Cert == "") != (s.Webhook.Key == "") {
+			r.add("webhook.cert", "both cert and key are required")
+		}
+
+		r.file("webhook.cert", s.Webhook.Cert)
+		r.file("webhook.key", s.Webhook.Key)
+		r.positive("webhook.check_delay", s.Webhook.CheckDelay)
+	}
+
+	switch s.Cot.Proto {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6", "multicast":
+		if s.Cot.Server != "" {
+			r.addr("cot.server", s.Cot.Server)
+		}
+	case "http":
+		if s.Cot.Server != "" {
+			r.url("cot.server", s.Cot.Server, "http", "https")
+		}
+
+		if s.Cot.Http.Api != ApiJSON && s.Cot.Http.Api != ApiXML {
+			r.add("cot.http.api", "unknown api %q, %s or %s expected", s.Cot.Http.Api, ApiJSON, ApiXML)
+		}
+
+		r.positive("cot.http.timeout", s.Cot.Http.Timeout)
+	default:
+		r.add("cot.proto", "unknown proto %q, http, multicast, tcp or udp (with optional 4 or 6) expected", s.Cot.Proto)
+	}
+
+	if !slices.Contains([]string{FormatMesh, FormatStream, FormatXML}, s.Cot.Format) {
+		r.add("cot.format", "unknown format %q, %s, %s or %s expected", s.Cot.Format, FormatMesh, FormatStream, FormatXML)
+	}
+
+	r.positive("cot.stale", s.Cot.Stale)
+
+	if s.Cot.Ttl < 1 || s.Cot.Ttl > 255 {
+		r.add("cot.ttl", "ttl must be 1..255")
+	}
+
+	for _, u := range s.Cot.Unicast {
+		r.addr("cot.unicast", u)
+	}
+
+	if s.Cot.Retry.Queue < 0 || s.Cot.Retry.Attempts < 0 {
+		r.add("cot.retry", "queue and attempts must not be negative")
+	}
+
+	r.positive("cot.retry.delay", s.Cot.Retry.Delay)
+
+	if s.Aprs.Server != "" {
+		r.addr("aprs.server", s.Aprs.Server)
+		r.positive("aprs.reconnect", s.Aprs.Reconnect)
+
+		if !s.Aprs.OnlyKnown && s.Aprs.Filter == "" {
+			r.add("aprs.filter", "filter is required to show not listed senders")
+		}
+	}
+
+	if s.Meshtastic.Broker != "" {
+		r.url("meshtastic.broker", s.Meshtastic.Broker, "tcp", "ssl", "tls", "ws", "wss")
+	}
+
+	if s.Tak.Server != "" {
+		r.addr("tak.server", s.Tak.Server)
+	}
+
+	if s.Mission.Url != "" {
+		r.url("mission.url", s.Mission.Url, "http", "https")
+		r.positive("mission.poll", s.Mission.Poll)
+	}
+
+	if s.Nearby.Radius <= 0 || s.Nearby.MaxRadius < s.Nearby.Radius {
+		r.add("nearby", "radius must be positive and not greater than max_radius")
+	}
+
+	r.positive("nearby.max_age", s.Nearby.MaxAge)
+	r.file("altitude.dem", s.Altitude.Dem)
+	r.file("altitude.geoid", s.Altitude.Geoid)
+
+	r.positive("dialog.timeout", s.Dialog.Timeout)
+
+	if s.Callsign.MinLen < 1 || s.Callsign.MaxLen < s.Callsign.MinLen {
+		r.add("callsign", "min_len must be positive and not greater than max_len")
+	}
+
+	if _, err := regexp.Compile(s.Callsign.Pattern); err != nil {
+		r.add("callsign.pattern", "%s", err.Error())
+	}
+
+	if len(s.Teams) == 0 {
+		r.add("teams", "team list is empty")
+	}
+
+	for _, t := range s.Teams {
+		if _, ok := teamColors[t]; !ok && t != NO_TEAM {
+			r.add("teams", "unknown team color %q", t)
+		}
+	}
+
+	if len(s.Roles) == 0 {
+		r.add("roles", "role list is empty")
+	}
+
+	if _, err := loadFences(c); err != nil {
+		r.add("fences", "%s", err.Error())
+	}
+
+	return r
+}
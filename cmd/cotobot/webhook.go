@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const secretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// startListener starts http(s) listener shared by webhooks of all bot instances
+func startListener(conf *AppConfig) {
+	listen := conf.String("webhook.listen")
+	slog.Default().Info("start listener on " + listen)
+
+	go func() {
+		var err error
+
+		if cert, key := conf.String("webhook.cert"), conf.String("webhook.key"); cert != "" && key != "" {
+			err = http.ListenAndServeTLS(listen, cert, key, nil)
+		} else {
+			err = http.ListenAndServe(listen, nil)
+		}
+
+		if err != nil {
+			panic(err)
+		}
+	}()
+}
+
+// startWebhook registers webhook in telegram and handler for incoming updates on shared listener
+func (t *TelegramTransport) startWebhook(webhook string) (tg.UpdatesChannel, error) {
+	allowed, err := parseNets(t.config.Strings("webhook.allow"))
+	if err != nil {
+		return nil, err
+	}
+
+	updates := t.listenWebhook(t.config.String("webhook.path"), allowed)
+
+	t.logger.Info(fmt.Sprintf("starting webhook %s, path %s", webhook, t.config.String("webhook.path")))
+
+	// errors left from time bot was down do not count
+	since := time.Now().Truncate(time.Second)
+
+	if err := t.setWebhook(webhook); err != nil {
+		return nil, err
+	}
+
+	// give telegram time to deliver pending updates
+	time.Sleep(t.config.Duration("webhook.check_delay"))
+
+	if err := t.checkWebhook(since); err != nil {
+		return nil, err
+	}
+
+	return updates, nil
+}
+
+// setWebhook calls setWebhook method directly, as tg.WebhookConfig has no secret_token field
+func (t *TelegramTransport) setWebhook(webhook string) error {
+	params := tg.Params{"url": webhook}
+	params.AddNonEmpty("secret_token", t.config.String("webhook.secret"))
+	params.AddNonEmpty("ip_address", t.config.String("webhook.ip_address"))
+
+	var err error
+
+	if cert := t.config.String("webhook.cert"); cert != "" && t.config.Bool("webhook.self_signed") {
+		t.logger.Info("uploading webhook certificate " + cert)
+		_, err = t.bot.UploadFiles("setWebhook", params, []tg.RequestFile{{Name: "certificate", Data: tg.FilePath(cert)}})
+	} else {
+		_, err = t.bot.MakeRequest("setWebhook", params)
+	}
+
+	return err
+}
+
+// checkWebhook returns error if telegram has undelivered updates and delivery failed after webhook was set
+func (t *TelegramTransport) checkWebhook(since time.Time) error {
+	info, err := t.bot.GetWebhookInfo()
+	if err != nil {
+		return err
+	}
+
+	if info.LastErrorDate == 0 {
+		return nil
+	}
+
+	errTime := time.Unix(int64(info.LastErrorDate), 0)
+	t.logger.Warn(fmt.Sprintf("webhook error at %s: %s", errTime.Format(time.RFC3339), info.LastErrorMessage),
+		"pending", info.PendingUpdateCount)
+
+	if info.PendingUpdateCount > 0 && !errTime.Before(since) {
+		return fmt.Errorf("telegram webhook delivery failed: %s", info.LastErrorMessage)
+	}
+
+	return nil
+}
+
+func (t *TelegramTransport) listenWebhook(path string, allowed []*net.IPNet) tg.UpdatesChannel {
+	ch := make(chan tg.Update, t.bot.Buffer)
+	secret := t.config.String("webhook.secret")
+	ipHeader := t.config.String("webhook.ip_header")
+
+	http.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(secret)) != 1 {
+			t.logger.Warn("webhook request with invalid secret", "addr", r.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if len(allowed) > 0 {
+			ip := clientIP(r, ipHeader)
+			if !ipAllowed(ip, allowed) {
+				t.logger.Warn("webhook request from not allowed address", "addr", ip)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+
+		update, err := t.bot.HandleUpdate(r)
+		if err != nil {
+			t.logger.Warn("bad webhook request", "error", err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		ch <- *update
+	})
+
+	return ch
+}
+
+func clientIP(r *http.Request, header string) net.IP {
+	if header != "" {
+		if s := r.Header.Get(header); s != "" {
+			// X-Forwarded-For may contain chain of proxies, first is the client
+			s, _, _ = strings.Cut(s, ",")
+			return net.ParseIP(strings.TrimSpace(s))
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return net.ParseIP(host)
+}
+
+func ipAllowed(ip net.IP, allowed []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range allowed {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseNets(s []string) ([]*net.IPNet, error) {
+	res := make([]*net.IPNet, 0, len(s))
+
+	for _, c := range s {
+		if !strings.Contains(c, "/") {
+			if strings.Contains(c, ":") {
+				c += "/128"
+			} else {
+				c += "/32"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %s: %w", c, err)
+		}
+
+		res = append(res, n)
+	}
+
+	return res, nil
+}
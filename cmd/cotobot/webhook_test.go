@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	conf := NewAppConfig()
+	conf.get().Set("webhook.secret", "s3cret")
+	conf.get().Set("webhook.ip_header", "X-Real-IP")
+
+	allowed, err := parseNets([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := NewTelegramTransport(conf)
+	tr.bot = &tg.BotAPI{Buffer: 1}
+	updates := tr.listenWebhook("/test-webhook", allowed)
+
+	srv := httptest.NewServer(http.DefaultServeMux)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name, secret, ip string
+		code             int
+	}{
+		{"no secret", "", "10.1.2.3", http.StatusUnauthorized},
+		{"wrong secret", "guess", "10.1.2.3", http.StatusUnauthorized},
+		{"not allowed address", "s3cret", "192.0.2.1", http.StatusForbidden},
+		{"ok", "s3cret", "10.1.2.3", http.StatusOK},
+	} {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/test-webhook", strings.NewReader(`{"update_id": 1}`))
+		req.Header.Set("X-Real-IP", tc.ip)
+
+		if tc.secret != "" {
+			req.Header.Set(secretHeader, tc.secret)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.code {
+			t.Errorf("%s: status %d, %d expected", tc.name, resp.StatusCode, tc.code)
+		}
+	}
+
+	if u := <-updates; u.UpdateID != 1 {
+		t.Errorf("update %d received", u.UpdateID)
+	}
+}